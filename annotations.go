@@ -0,0 +1,88 @@
+package barrister
+
+import (
+	"strings"
+)
+
+// The Barrister IDL grammar has no syntax for modifiers on functions, so
+// barrister-go reads them from the IDL comment that precedes the function.
+// An annotation is a comment line that starts with '@', optionally followed
+// by a value.  For example:
+//
+//     // returns num as an array repeated 'count' number of times
+//     // @stream
+//     repeat_num(num int, count int) []int
+//
+// Annotations recognized by this package:
+//
+//     @stream - the function result is delivered incrementally (see Server.CallStream)
+//     @event  - the function describes a notification that clients subscribe to
+//               (see Server.Publish).  The params are the subscription arguments and
+//               the return type is the notification payload.
+//     @idempotent - calling the function more than once has the same effect as calling
+//               it once, so a failed call may be retried (see RetryPolicy)
+//     @public, @roles, @scopes - authorization policy of the function (see AuthzFilter)
+//     @cache  - results of the function may be cached for the given duration, e.g. "30s"
+//               (see CacheFilter)
+//     @deprecated - the element should no longer be used.  The optional value gives
+//               the reason or the replacement, and becomes the "Deprecated:" paragraph
+//               of the Go doc comment generated by idl2go.  Unlike the others, it may
+//               be put on any IDL element.
+//
+
+// parseAnnotations returns the annotations found in the given comment,
+// keyed by name (without the leading '@').  Annotations without a value
+// map to an empty string.
+func parseAnnotations(comment string) map[string]string {
+	annotations := map[string]string{}
+	for _, ln := range strings.Split(comment, "\n") {
+		ln = strings.TrimSpace(ln)
+		if !isAnnotation(ln) {
+			continue
+		}
+
+		name, val := ln[1:], ""
+		if i := strings.IndexAny(name, " \t"); i > -1 {
+			name, val = name[:i], strings.TrimSpace(name[i+1:])
+		}
+		annotations[name] = val
+	}
+	return annotations
+}
+
+// isAnnotation returns true if the comment line is read by parseAnnotations
+func isAnnotation(ln string) bool {
+	ln = strings.TrimSpace(ln)
+	return strings.HasPrefix(ln, "@") && len(ln) >= 2
+}
+
+// Annotation returns the value of the named annotation on this Function
+// and true if the annotation is present.
+func (f Function) Annotation(name string) (string, bool) {
+	val, ok := parseAnnotations(f.Comment)[name]
+	return val, ok
+}
+
+// IsStream returns true if the function is annotated with @stream and
+// returns an array.  The items of a stream function's result are delivered
+// to the caller one at a time.
+func (f Function) IsStream() bool {
+	_, ok := f.Annotation("stream")
+	return ok && f.Returns.IsArray
+}
+
+// IsEvent returns true if the function is annotated with @event.  Event
+// functions cannot be called.  Clients subscribe to them instead.
+func (f Function) IsEvent() bool {
+	_, ok := f.Annotation("event")
+	return ok
+}
+
+// streamItem returns the IDL field describing a single item of the
+// stream function's result.
+func (f Function) streamItem() Field {
+	item := f.Returns
+	item.IsArray = false
+	item.Optional = false
+	return item
+}
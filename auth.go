@@ -0,0 +1,270 @@
+package barrister
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrCodeUnauthenticated is the JsonRpcError code returned by AuthFilter
+// for requests without valid credentials
+const ErrCodeUnauthenticated = -32001
+
+// Principal is the authenticated identity of a caller
+type Principal struct {
+	// e.g. the JWT subject, HMAC key id or certificate common name
+	Id string
+
+	// Authenticator that established the identity: "bearer", "hmac" or "mtls"
+	Scheme string
+
+	Roles []string
+
+	// Additional attributes, e.g. the JWT claims
+	Claims map[string]interface{}
+}
+
+// HasRole returns true if p has the given role
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope returns true if the "scope" or "scp" claim of p contains the
+// given scope.  The claim may be a space separated string or a JSON array.
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+	return containsString(jwtRoles(p.Claims["scope"]), scope) ||
+		containsString(jwtRoles(p.Claims["scp"]), scope)
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx that carries p
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the Principal carried by ctx, or nil
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// principalId returns the Id of the request's Principal, or "" if the
+// request was not authenticated
+func principalId(r *RequestResponse) string {
+	if p := PrincipalFromContext(r.Context); p != nil {
+		return p.Id
+	}
+	return ""
+}
+
+// Authenticator establishes the identity of a caller from the request Headers
+type Authenticator interface {
+	// Authenticate returns the Principal identified by the credentials in
+	// headers.  If headers carry no credentials that this Authenticator
+	// understands, Authenticate returns nil, nil.  If the credentials are
+	// invalid, an error is returned.
+	Authenticate(headers *Headers) (*Principal, error)
+}
+
+// AuthFilter is a Filter that authenticates each request with the first
+// Authenticator that recognizes its credentials.  The Principal is passed to
+// later Filters in RequestResponse.Context (see PrincipalFromContext), and
+// to handlers through Headers.Principal.
+//
+// Requests with invalid credentials, or without credentials unless
+// AllowAnonymous is set, fail with code ErrCodeUnauthenticated.
+//
+//     svr.AddFilter(barrister.NewAuthFilter(
+//         &barrister.JWTAuthenticator{Keys: map[string]interface{}{"": secret}},
+//         &barrister.CertAuthenticator{},
+//     ))
+//
+type AuthFilter struct {
+	Authenticators []Authenticator
+
+	// If true, requests without credentials proceed without a Principal
+	AllowAnonymous bool
+}
+
+// NewAuthFilter creates an AuthFilter that tries the given Authenticators in order
+func NewAuthFilter(authenticators ...Authenticator) *AuthFilter {
+	return &AuthFilter{Authenticators: authenticators}
+}
+
+// PreInvoke authenticates the request
+func (f *AuthFilter) PreInvoke(r *RequestResponse) bool {
+	for _, a := range f.Authenticators {
+		p, err := a.Authenticate(&r.Headers)
+		if err != nil {
+			r.Err = &JsonRpcError{Code: ErrCodeUnauthenticated,
+				Message: fmt.Sprintf("barrister: authentication failed: %s", err)}
+			return false
+		}
+		if p != nil {
+			if r.Headers.call != nil {
+				r.Headers.call.principal = p
+			}
+			r.Context = ContextWithPrincipal(r.Context, p)
+			return true
+		}
+	}
+
+	if f.AllowAnonymous {
+		return true
+	}
+	r.Err = &JsonRpcError{Code: ErrCodeUnauthenticated, Message: "barrister: authentication required"}
+	return false
+}
+
+// PostInvoke does nothing
+func (f *AuthFilter) PostInvoke(r *RequestResponse) bool {
+	return true
+}
+
+// bearerToken returns the token of an "Authorization: Bearer <token>" header
+func bearerToken(headers *Headers) string {
+	auth := GetFirst(headers.Request, "Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// BearerAuthenticator authenticates requests that carry an
+// "Authorization: Bearer <token>" header with Verify.  For JWTs that can be
+// verified locally, use JWTAuthenticator.
+type BearerAuthenticator struct {
+	// Returns the Principal for a token, or an error if it is invalid
+	Verify func(token string) (*Principal, error)
+}
+
+// Authenticate implements Authenticator
+func (a *BearerAuthenticator) Authenticate(headers *Headers) (*Principal, error) {
+	token := bearerToken(headers)
+	if token == "" {
+		return nil, nil
+	}
+	p, err := a.Verify(token)
+	if err == nil && p != nil && p.Scheme == "" {
+		p.Scheme = "bearer"
+	}
+	return p, err
+}
+
+// HMACScheme is the Authorization scheme of requests signed by HMACSigner:
+//
+//     Authorization: Barrister-HMAC keyId=<key id>,ts=<unix seconds>,sig=<signature>
+//
+// The signature is the hex encoded HMAC-SHA256 of the timestamp, a newline,
+// and the request body.
+const HMACScheme = "Barrister-HMAC"
+
+// hmacSignature returns the signature of body at ts
+func hmacSignature(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HMACAuthenticator authenticates HTTP requests whose body is signed with a
+// shared secret (see HMACScheme and HMACSigner).  The Principal Id is the key id.
+//
+// Requests are only accepted within MaxSkew of their timestamp, but a signed
+// request may be replayed within that window.
+type HMACAuthenticator struct {
+	// Shared secrets by key id
+	Keys map[string][]byte
+
+	// Maximum difference between the request timestamp and the server
+	// clock.  Defaults to 5 minutes.
+	MaxSkew time.Duration
+}
+
+// Authenticate implements Authenticator
+func (a *HMACAuthenticator) Authenticate(headers *Headers) (*Principal, error) {
+	auth := GetFirst(headers.Request, "Authorization")
+	if !strings.HasPrefix(auth, HMACScheme+" ") {
+		return nil, nil
+	}
+
+	params := map[string]string{}
+	for _, kv := range strings.Split(auth[len(HMACScheme)+1:], ",") {
+		pair := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(pair) == 2 {
+			params[pair[0]] = pair[1]
+		}
+	}
+
+	secret, ok := a.Keys[params["keyId"]]
+	if !ok {
+		return nil, fmt.Errorf("unknown HMAC key id: %s", params["keyId"])
+	}
+
+	ts, err := strconv.ParseInt(params["ts"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HMAC timestamp: %s", params["ts"])
+	}
+	maxSkew := a.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = 5 * time.Minute
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return nil, fmt.Errorf("HMAC timestamp outside allowed skew")
+	}
+
+	expected := hmacSignature(secret, params["ts"], headers.Body)
+	if !hmac.Equal([]byte(expected), []byte(params["sig"])) {
+		return nil, fmt.Errorf("invalid HMAC signature")
+	}
+	return &Principal{Id: params["keyId"], Scheme: "hmac"}, nil
+}
+
+// CertAuthenticator authenticates requests received over TLS with a verified
+// client certificate.  The server's tls.Config must set ClientAuth to
+// tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert.
+type CertAuthenticator struct {
+	// Optional func that returns the Principal for a verified certificate.
+	// By default the Principal Id is the subject common name.
+	Map func(cert *x509.Certificate) (*Principal, error)
+}
+
+// Authenticate implements Authenticator
+func (a *CertAuthenticator) Authenticate(headers *Headers) (*Principal, error) {
+	if headers.TLS == nil || len(headers.TLS.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	if len(headers.TLS.VerifiedChains) == 0 {
+		return nil, fmt.Errorf("client certificate was not verified")
+	}
+
+	cert := headers.TLS.PeerCertificates[0]
+	if a.Map != nil {
+		p, err := a.Map(cert)
+		if err == nil && p != nil && p.Scheme == "" {
+			p.Scheme = "mtls"
+		}
+		return p, err
+	}
+	return &Principal{Id: cert.Subject.CommonName, Scheme: "mtls"}, nil
+}
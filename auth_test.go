@@ -0,0 +1,344 @@
+package barrister
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// principalCounter records the Principal seen by each call to Total
+type principalCounter struct {
+	CounterImpl
+	headers Headers
+	seen    *principalLog
+}
+
+type principalLog struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (c principalCounter) CloneForReq(headers Headers) interface{} {
+	return principalCounter{headers: headers, seen: c.seen}
+}
+
+func (c principalCounter) Total(n int64) (int64, error) {
+	id := "<nil>"
+	if p := c.headers.Principal(); p != nil {
+		id = p.Id
+	}
+	c.seen.mu.Lock()
+	c.seen.ids = append(c.seen.ids, id)
+	c.seen.mu.Unlock()
+	return c.CounterImpl.Total(n)
+}
+
+func newAuthServer(f *AuthFilter) (Server, *principalLog) {
+	seen := &principalLog{}
+	svr := NewJSONServer(MustParseIdlJson([]byte(streamIdlJson)), true)
+	svr.AddHandler("Counter", principalCounter{seen: seen})
+	svr.AddFilter(f)
+	return svr, seen
+}
+
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func bearerHeaders(token string) Headers {
+	h := newHeaders()
+	h.Request["Authorization"] = []string{"Bearer " + token}
+	return h
+}
+
+func expectAuthError(t *testing.T, err error) {
+	e, ok := err.(*JsonRpcError)
+	if !ok || e.Code != ErrCodeUnauthenticated {
+		t.Errorf("expected ErrCodeUnauthenticated, got: %v", err)
+	}
+}
+
+func TestAuthFilterJWT(t *testing.T) {
+	secret := []byte("s3cret")
+	svr, seen := newAuthServer(NewAuthFilter(&JWTAuthenticator{
+		Keys:     map[string]interface{}{"": secret},
+		Issuer:   "issuer",
+		Audience: "counter",
+	}))
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	token := signJWT(t, "HS256", "", secret, map[string]interface{}{
+		"sub": "alice", "iss": "issuer", "aud": []string{"counter"}, "exp": exp, "roles": []string{"admin"},
+	})
+	_, err := svr.Call(bearerHeaders(token), "Counter.total", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen.ids) != 1 || seen.ids[0] != "alice" {
+		t.Errorf("handler did not see principal: %v", seen.ids)
+	}
+
+	bad := map[string]map[string]interface{}{
+		"expired":      {"sub": "alice", "iss": "issuer", "aud": "counter", "exp": float64(time.Now().Add(-time.Hour).Unix())},
+		"not before":   {"sub": "alice", "iss": "issuer", "aud": "counter", "nbf": exp},
+		"wrong issuer": {"sub": "alice", "iss": "other", "aud": "counter"},
+		"wrong aud":    {"sub": "alice", "iss": "issuer", "aud": "other"},
+		"aud list":     {"sub": "alice", "iss": "issuer", "aud": "other counter"},
+		"aud prefix":   {"sub": "alice", "iss": "issuer", "aud": []string{"counter-admin"}},
+	}
+	for name, claims := range bad {
+		_, err = svr.Call(bearerHeaders(signJWT(t, "HS256", "", secret, claims)), "Counter.total", 3)
+		if err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+		expectAuthError(t, err)
+	}
+
+	_, err = svr.Call(bearerHeaders(signJWT(t, "HS256", "", []byte("wrong"), map[string]interface{}{"sub": "a"})),
+		"Counter.total", 3)
+	expectAuthError(t, err)
+
+	_, err = svr.Call(newHeaders(), "Counter.total", 3)
+	expectAuthError(t, err)
+	if len(seen.ids) != 1 {
+		t.Errorf("handler called for rejected requests: %v", seen.ids)
+	}
+}
+
+func TestJWTAuthenticatorRequireExp(t *testing.T) {
+	secret := []byte("s3cret")
+	auth := &JWTAuthenticator{Keys: map[string]interface{}{"": secret}}
+	token := signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "alice"})
+
+	_, err := auth.Verify(token)
+	if err != nil {
+		t.Errorf("token without exp rejected: %v", err)
+	}
+
+	auth.RequireExp = true
+	_, err = auth.Verify(token)
+	if err == nil {
+		t.Errorf("token without exp accepted")
+	}
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	_, err = auth.Verify(signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "alice", "exp": exp}))
+	if err != nil {
+		t.Errorf("token with exp rejected: %v", err)
+	}
+}
+
+func TestAuthFilterAllowAnonymous(t *testing.T) {
+	f := NewAuthFilter(&BearerAuthenticator{Verify: func(token string) (*Principal, error) {
+		return &Principal{Id: token}, nil
+	}})
+	f.AllowAnonymous = true
+	svr, seen := newAuthServer(f)
+
+	_, err := svr.Call(newHeaders(), "Counter.total", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svr.Call(bearerHeaders("bob"), "Counter.total", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen.ids) != 2 || seen.ids[0] != "<nil>" || seen.ids[1] != "bob" {
+		t.Errorf("unexpected principals: %v", seen.ids)
+	}
+}
+
+func TestJWTAuthenticatorPublicKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := &JWTAuthenticator{RolesClaim: "scope", Keys: map[string]interface{}{
+		"rsa": &rsaKey.PublicKey,
+		"ec":  &ecKey.PublicKey,
+	}}
+	claims := map[string]interface{}{"sub": "svc", "scope": "read write"}
+
+	p, err := auth.Verify(signJWT(t, "RS256", "rsa", rsaKey, claims))
+	if err != nil || p.Id != "svc" || !p.HasRole("write") {
+		t.Errorf("RS256 verify failed: %v %+v", err, p)
+	}
+	p, err = auth.Verify(signJWT(t, "ES256", "ec", ecKey, claims))
+	if err != nil || p.Id != "svc" || !p.HasRole("read") {
+		t.Errorf("ES256 verify failed: %v %+v", err, p)
+	}
+
+	// an HMAC token must not verify against a public key
+	_, err = auth.Verify(signJWT(t, "HS256", "rsa", []byte("x"), claims))
+	if err == nil {
+		t.Errorf("HS256 token verified with RSA public key")
+	}
+	_, err = auth.Verify(signJWT(t, "RS256", "unknown", rsaKey, claims))
+	if err == nil {
+		t.Errorf("token with unknown kid verified")
+	}
+}
+
+func TestHMACSignedRequests(t *testing.T) {
+	secret := []byte("shared")
+	svr, seen := newAuthServer(NewAuthFilter(&HMACAuthenticator{Keys: map[string][]byte{"billing": secret}}))
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	trans := &HttpTransport{Url: ts.URL, Hook: &HMACSigner{KeyId: "billing", Secret: secret}}
+	_, err := NewRemoteClient(trans, true).Call("Counter.total", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen.ids) != 1 || seen.ids[0] != "billing" {
+		t.Errorf("unexpected principals: %v", seen.ids)
+	}
+
+	trans = &HttpTransport{Url: ts.URL, Hook: &HMACSigner{KeyId: "billing", Secret: []byte("wrong")}}
+	_, err = NewRemoteClient(trans, true).Call("Counter.total", 4)
+	expectAuthError(t, err)
+}
+
+func TestHMACSignedConnRequests(t *testing.T) {
+	secret := []byte("shared")
+	svr, seen := newAuthServer(NewAuthFilter(&HMACAuthenticator{Keys: map[string][]byte{"billing": secret}}))
+
+	req := []byte(`{"jsonrpc":"2.0","id":"1","method":"Counter.total","params":[4]}`)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := newHeaders()
+	headers.Request["Authorization"] = []string{fmt.Sprintf("%s keyId=billing,ts=%s,sig=%s",
+		HMACScheme, ts, hmacSignature(secret, ts, req))}
+
+	serverConn, clientConn := net.Pipe()
+	go svr.ServeConn(serverConn, headers)
+	defer clientConn.Close()
+
+	_, err := clientConn.Write(append(req, '\n'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := bufio.NewReader(clientConn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rpcResp JsonRpcResponse
+	err = json.Unmarshal(resp, &rpcResp)
+	if err != nil || rpcResp.Error != nil {
+		t.Fatalf("unexpected response: %s %v", resp, err)
+	}
+	if len(seen.ids) != 1 || seen.ids[0] != "billing" {
+		t.Errorf("unexpected principals: %v", seen.ids)
+	}
+}
+
+func TestBearerCredentials(t *testing.T) {
+	svr, seen := newAuthServer(NewAuthFilter(&BearerAuthenticator{Verify: func(token string) (*Principal, error) {
+		return &Principal{Id: token}, nil
+	}}))
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	client := NewRemoteClient(&HttpTransport{Url: ts.URL}, true).(*RemoteClient)
+	client.AddInterceptor(NewBearerCredentials("carol"))
+	_, err := client.Call("Counter.total", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen.ids) != 1 || seen.ids[0] != "carol" {
+		t.Errorf("unexpected principals: %v", seen.ids)
+	}
+}
+
+func TestCertAuthenticator(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "svc-a"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, _ := x509.ParseCertificate(der)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	svr, seen := newAuthServer(NewAuthFilter(&CertAuthenticator{}))
+	ts := httptest.NewUnstartedServer(&svr)
+	ts.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	rt := NewMutualTLSRoundTripper(tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots)
+	_, err = NewRemoteClient(&HttpTransport{Url: ts.URL, RoundTripper: rt}, true).Call("Counter.total", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen.ids) != 1 || seen.ids[0] != "svc-a" {
+		t.Errorf("unexpected principals: %v", seen.ids)
+	}
+
+	// no client certificate
+	_, err = NewRemoteClient(&HttpTransport{Url: ts.URL, Client: ts.Client()}, true).Call("Counter.total", 4)
+	expectAuthError(t, err)
+}
@@ -0,0 +1,193 @@
+package barrister
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrCodeForbidden is the JsonRpcError code returned by AuthzFilter for
+// calls that the caller's Principal is not permitted to make
+const ErrCodeForbidden = -32002
+
+// Policy decides who may call an IDL method.  A call is allowed if the
+// Policy is Public, or if the caller was authenticated and passes every
+// check that is set.
+type Policy struct {
+	// If true, anyone may call, including unauthenticated callers
+	Public bool
+
+	// The Principal must have at least one of these roles
+	Roles []string
+
+	// The Principal must have all of these scopes (see Principal.HasScope)
+	Scopes []string
+
+	// Optional predicate over the Principal and the request params, keyed by
+	// IDL param name.  Params are not yet converted, so for requests decoded
+	// from JSON, structs are map[string]interface{} and numbers are float64.
+	Allow func(p *Principal, params map[string]interface{}) bool
+}
+
+// AuthzFilter is a Filter that checks each call against the Policy of its
+// IDL method, and rejects it before the handler runs.  Calls without a
+// Principal fail with code ErrCodeUnauthenticated, and calls that the
+// Principal may not make fail with code ErrCodeForbidden.  Register
+// AuthzFilter after the AuthFilter that establishes the Principal.
+//
+// Policies are read from the IDL function annotations @public, @roles and
+// @scopes, whose values are space separated:
+//
+//     // @roles admin
+//     delete(userId string) bool
+//
+// Policies may also be set in code for a method ("UserService.delete"), an
+// interface ("UserService"), or all methods ("*").  The most specific Policy
+// applies, and a Policy set in code replaces the annotations of the method.
+//
+//     authz := barrister.NewAuthzFilter(idl)
+//     authz.Set("UserService", barrister.Policy{Roles: []string{"user"}})
+//     authz.Set("UserService.delete", barrister.Policy{Roles: []string{"admin"}})
+//     svr.AddFilter(barrister.NewAuthFilter(jwtAuth))
+//     svr.AddFilter(authz)
+//
+// Subscriptions to @event functions (see Server.Publish) are checked against
+// the Policy of the event, with the subscription params as its params.
+//
+// Methods without a Policy are denied unless AllowUnprotected is set.  Use
+// Unprotected to list them, e.g. in a test or at startup.
+type AuthzFilter struct {
+	// If true, calls to methods without a Policy are allowed
+	AllowUnprotected bool
+
+	idl      *Idl
+	policies map[string]Policy
+}
+
+// NewAuthzFilter creates an AuthzFilter with the policies annotated in idl
+func NewAuthzFilter(idl *Idl) *AuthzFilter {
+	f := &AuthzFilter{idl: idl, policies: map[string]Policy{}}
+	for name, fn := range idl.methods {
+		p, ok := annotatedPolicy(fn)
+		if ok {
+			f.policies[name] = p
+		}
+	}
+	return f
+}
+
+// annotatedPolicy returns the Policy described by fn's annotations
+func annotatedPolicy(fn Function) (Policy, bool) {
+	a := parseAnnotations(fn.Comment)
+	_, public := a["public"]
+	roles, hasRoles := a["roles"]
+	scopes, hasScopes := a["scopes"]
+	if !public && !hasRoles && !hasScopes {
+		return Policy{}, false
+	}
+	return Policy{Public: public, Roles: strings.Fields(roles), Scopes: strings.Fields(scopes)}, true
+}
+
+// Set sets the Policy of a method ("Iface.method"), an interface ("Iface"),
+// or all methods ("*").  The InfoInterface and its methods may also be set.
+// Set panics if the name is not in the IDL, as a typo would leave the
+// intended method unprotected.  Set must not be called while the Server is
+// handling requests.
+func (f *AuthzFilter) Set(name string, p Policy) *AuthzFilter {
+	_, isMethod := f.idl.methods[name]
+	_, isIface := f.idl.interfaces[name]
+	_, isInfo := infoIdl.methods[name]
+	if !isMethod && !isIface && !isInfo && name != InfoInterface && name != "*" {
+		panic(fmt.Sprintf("barrister: AuthzFilter.Set name not found in IDL: %s", name))
+	}
+	f.policies[name] = p
+	return f
+}
+
+// policy returns the most specific Policy for method
+func (f *AuthzFilter) policy(method string) (Policy, bool) {
+	if p, ok := f.policies[method]; ok {
+		return p, true
+	}
+	iface, _ := parseMethod(method)
+	if p, ok := f.policies[iface]; ok {
+		return p, true
+	}
+	p, ok := f.policies["*"]
+	return p, ok
+}
+
+// Unprotected returns the IDL methods, including @event functions, that
+// have no Policy, sorted
+func (f *AuthzFilter) Unprotected() []string {
+	var names []string
+	for name := range f.idl.methods {
+		if _, ok := f.policy(name); !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PreInvoke rejects the call if its Policy does not allow it
+func (f *AuthzFilter) PreInvoke(r *RequestResponse) bool {
+	p, ok := f.policy(r.Method)
+	if !ok {
+		if f.AllowUnprotected {
+			return true
+		}
+		r.Err = &JsonRpcError{Code: ErrCodeForbidden,
+			Message: fmt.Sprintf("barrister: no authorization policy for %s", r.Method)}
+		return false
+	}
+	if p.Public {
+		return true
+	}
+
+	principal := PrincipalFromContext(r.Context)
+	if principal == nil {
+		r.Err = &JsonRpcError{Code: ErrCodeUnauthenticated, Message: "barrister: authentication required"}
+		return false
+	}
+
+	allowed := len(p.Roles) == 0
+	for _, role := range p.Roles {
+		if principal.HasRole(role) {
+			allowed = true
+			break
+		}
+	}
+	for _, scope := range p.Scopes {
+		if !principal.HasScope(scope) {
+			allowed = false
+		}
+	}
+	if allowed && p.Allow != nil {
+		allowed = p.Allow(principal, f.namedParams(r.Method, r.Params))
+	}
+
+	if !allowed {
+		r.Err = &JsonRpcError{Code: ErrCodeForbidden,
+			Message: fmt.Sprintf("barrister: %s is not permitted to call %s", principal.Id, r.Method)}
+		return false
+	}
+	return true
+}
+
+// PostInvoke does nothing
+func (f *AuthzFilter) PostInvoke(r *RequestResponse) bool {
+	return true
+}
+
+// namedParams returns the params keyed by IDL param name
+func (f *AuthzFilter) namedParams(method string, params []interface{}) map[string]interface{} {
+	idlFunc := f.idl.Method(method)
+	named := make(map[string]interface{}, len(params))
+	for i, p := range params {
+		if i < len(idlFunc.Params) {
+			named[idlFunc.Params[i].Name] = p
+		}
+	}
+	return named
+}
@@ -0,0 +1,130 @@
+package barrister
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newAuthzServer(authz func(idl *Idl) *AuthzFilter) Server {
+	idl := MustParseIdlJson([]byte(strings.Replace(streamIdlJson,
+		`"comment": "@idempotent"`, `"comment": "@idempotent\n@roles admin"`, 1)))
+
+	auth := NewAuthFilter(&BearerAuthenticator{Verify: func(token string) (*Principal, error) {
+		p := &Principal{Id: token, Claims: map[string]interface{}{"scope": "counter:read"}}
+		if token == "alice" {
+			p.Roles = []string{"admin"}
+		}
+		return p, nil
+	}})
+	auth.AllowAnonymous = true
+
+	svr := NewJSONServer(idl, true)
+	svr.AddHandler("Counter", CounterImpl{})
+	svr.AddFilter(auth)
+	svr.AddFilter(authz(idl))
+	return svr
+}
+
+func expectErrCode(t *testing.T, err error, code int) {
+	e, ok := err.(*JsonRpcError)
+	if !ok || e.Code != code {
+		t.Errorf("expected error code %d, got: %v", code, err)
+	}
+}
+
+func TestAuthzFilterAnnotations(t *testing.T) {
+	var authz *AuthzFilter
+	svr := newAuthzServer(func(idl *Idl) *AuthzFilter {
+		authz = NewAuthzFilter(idl)
+		return authz
+	})
+
+	if !reflect.DeepEqual(authz.Unprotected(), []string{"Counter.changed", "Counter.count"}) {
+		t.Errorf("unexpected unprotected methods: %v", authz.Unprotected())
+	}
+
+	_, err := svr.Call(bearerHeaders("alice"), "Counter.total", 3)
+	if err != nil {
+		t.Errorf("admin rejected: %v", err)
+	}
+	_, err = svr.Call(bearerHeaders("bob"), "Counter.total", 3)
+	expectErrCode(t, err, ErrCodeForbidden)
+	_, err = svr.Call(newHeaders(), "Counter.total", 3)
+	expectErrCode(t, err, ErrCodeUnauthenticated)
+
+	// methods without a policy are denied by default
+	_, err = svr.Call(bearerHeaders("alice"), "Counter.count", 3)
+	expectErrCode(t, err, ErrCodeForbidden)
+	authz.AllowUnprotected = true
+	_, err = svr.Call(bearerHeaders("alice"), "Counter.count", 3)
+	if err != nil {
+		t.Errorf("unprotected method rejected: %v", err)
+	}
+}
+
+func TestAuthzFilterPolicies(t *testing.T) {
+	svr := newAuthzServer(func(idl *Idl) *AuthzFilter {
+		return NewAuthzFilter(idl).
+			Set("Counter", Policy{Scopes: []string{"counter:read"}}).
+			Set("Counter.total", Policy{Allow: func(p *Principal, params map[string]interface{}) bool {
+				return p.Id == "bob" && params["n"] == 3
+			}})
+	})
+
+	_, err := svr.Call(bearerHeaders("bob"), "Counter.count", 3)
+	if err != nil {
+		t.Errorf("interface policy rejected call: %v", err)
+	}
+
+	// the method policy replaces the @roles annotation
+	_, err = svr.Call(bearerHeaders("bob"), "Counter.total", 3)
+	if err != nil {
+		t.Errorf("predicate rejected call: %v", err)
+	}
+	_, err = svr.Call(bearerHeaders("bob"), "Counter.total", 30)
+	expectErrCode(t, err, ErrCodeForbidden)
+	_, err = svr.Call(bearerHeaders("alice"), "Counter.total", 3)
+	expectErrCode(t, err, ErrCodeForbidden)
+
+	svr = newAuthzServer(func(idl *Idl) *AuthzFilter {
+		return NewAuthzFilter(idl).Set("*", Policy{Public: true})
+	})
+	_, err = svr.Call(newHeaders(), "Counter.count", 3)
+	if err != nil {
+		t.Errorf("public policy rejected call: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Set did not panic for a name not in the IDL")
+		}
+	}()
+	NewAuthzFilter(parseTestIdl()).Set("Counter.totl", Policy{Public: true})
+}
+
+func TestAuthzFilterSubscriptions(t *testing.T) {
+	svr := newAuthzServer(func(idl *Idl) *AuthzFilter {
+		return NewAuthzFilter(idl).
+			Set("Counter.changed", Policy{Allow: func(p *Principal, params map[string]interface{}) bool {
+				return params["name"] == p.Id
+			}})
+	})
+
+	subscribe := func(headers Headers, name string) error {
+		serverConn, clientConn := net.Pipe()
+		go svr.ServeConn(serverConn, headers)
+		trans := NewConnTransport(clientConn)
+		defer trans.Close()
+		_, err := Subscribe(NewRemoteClient(trans, false), "Counter.changed", func(p interface{}) {}, name)
+		return err
+	}
+
+	err := subscribe(bearerHeaders("bob"), "bob")
+	if err != nil {
+		t.Errorf("subscription rejected: %v", err)
+	}
+	expectErrCode(t, subscribe(bearerHeaders("bob"), "alice"), ErrCodeForbidden)
+	expectErrCode(t, subscribe(newHeaders(), "bob"), ErrCodeUnauthenticated)
+}
@@ -0,0 +1,331 @@
+package barrister
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalancePolicy determines which endpoint a BalancingTransport sends
+// each request to
+type BalancePolicy int
+
+const (
+	// RoundRobin sends requests to each healthy endpoint in turn
+	RoundRobin BalancePolicy = iota
+
+	// LeastInFlight sends each request to the healthy endpoint with the
+	// fewest requests awaiting a response
+	LeastInFlight
+
+	// ConsistentHash sends requests that carry the same value of
+	// BalanceOptions.HashHeader to the same endpoint, for as long as that
+	// endpoint is healthy.  Requests without the header are sent round robin.
+	ConsistentHash
+)
+
+// BalanceOptions configure a BalancingTransport.  Either Endpoints or
+// Resolver must be set.
+type BalanceOptions struct {
+	// Static list of endpoint URLs
+	Endpoints []string
+
+	// Optional function that returns the current endpoint URLs.  It is
+	// called when the transport is created and every ResolveInterval.
+	// If it fails, the previous endpoints are kept.
+	Resolver func() ([]string, error)
+
+	// Defaults to 30s
+	ResolveInterval time.Duration
+
+	Policy BalancePolicy
+
+	// Request header used by ConsistentHash.  Set it per call with
+	// WithRequestHeader.
+	HashHeader string
+
+	// Number of consecutive failed requests after which an endpoint is
+	// ejected.  Defaults to 3.
+	MaxFailures int
+
+	// How often ejected endpoints are probed with a barrister-idl request.
+	// An endpoint that answers the probe is re-admitted.  Defaults to 5s.
+	ProbeInterval time.Duration
+
+	// Creates the Transport for an endpoint URL.  Defaults to an
+	// HttpTransport with default settings.
+	NewTransport func(url string) Transport
+}
+
+// BalancingTransport is a Transport that spreads requests across several
+// endpoints of the same service.  A request that fails is not resent to another
+// endpoint.  Use a RetryPolicy on the RemoteClient for that.
+//
+// BalancingTransport is safe for concurrent use.  Close stops its background
+// probing.
+type BalancingTransport struct {
+	opts BalanceOptions
+	next uint32
+	stop chan struct{}
+
+	// guards endpoints and ring
+	mu        sync.RWMutex
+	endpoints []*endpoint
+	ring      []ringNode
+}
+
+// endpoint is a single destination of a BalancingTransport
+type endpoint struct {
+	url      string
+	trans    Transport
+	inFlight int32
+
+	// guarded by BalancingTransport.mu
+	failures int
+	healthy  bool
+}
+
+// ringNode is a point on the consistent hash ring
+type ringNode struct {
+	hash uint32
+	ep   *endpoint
+}
+
+// virtual nodes per endpoint on the consistent hash ring
+const ringReplicas = 100
+
+// NewBalancingTransport creates a BalancingTransport and starts probing
+// ejected endpoints.  An error is returned if the options contain no endpoints
+// and the Resolver fails.
+func NewBalancingTransport(opts BalanceOptions) (*BalancingTransport, error) {
+	if opts.ResolveInterval <= 0 {
+		opts.ResolveInterval = 30 * time.Second
+	}
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = 3
+	}
+	if opts.ProbeInterval <= 0 {
+		opts.ProbeInterval = 5 * time.Second
+	}
+	if opts.NewTransport == nil {
+		opts.NewTransport = func(url string) Transport {
+			return &HttpTransport{Url: url}
+		}
+	}
+
+	t := &BalancingTransport{opts: opts, stop: make(chan struct{})}
+	urls := opts.Endpoints
+	if opts.Resolver != nil {
+		resolved, err := opts.Resolver()
+		if err != nil && len(urls) == 0 {
+			return nil, fmt.Errorf("barrister: BalancingTransport Resolver failed: %s", err)
+		}
+		if err == nil {
+			urls = resolved
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("barrister: BalancingTransport has no endpoints")
+	}
+	t.setEndpoints(urls)
+
+	go t.probeLoop()
+	return t, nil
+}
+
+// Send sends the request to the endpoint chosen by the BalancePolicy
+func (t *BalancingTransport) Send(in []byte) ([]byte, error) {
+	return t.SendContext(context.Background(), in)
+}
+
+// SendContext sends the request to the endpoint chosen by the BalancePolicy,
+// bounded by the context
+func (t *BalancingTransport) SendContext(ctx context.Context, in []byte) ([]byte, error) {
+	ep := t.pick(ctx)
+	if ep == nil {
+		return nil, fmt.Errorf("barrister: BalancingTransport has no healthy endpoints")
+	}
+
+	atomic.AddInt32(&ep.inFlight, 1)
+	out, err := sendContext(ctx, ep.trans, in)
+	atomic.AddInt32(&ep.inFlight, -1)
+
+	if err != nil && ctx.Err() == nil {
+		t.markFailed(ep)
+	} else if err == nil {
+		t.markHealthy(ep)
+	}
+	return out, err
+}
+
+// Endpoints returns the URLs of all endpoints, and whether each is healthy
+func (t *BalancingTransport) Endpoints() map[string]bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	m := make(map[string]bool, len(t.endpoints))
+	for _, ep := range t.endpoints {
+		m[ep.url] = ep.healthy
+	}
+	return m
+}
+
+// Close stops probing and resolving endpoints
+func (t *BalancingTransport) Close() error {
+	select {
+	case <-t.stop:
+	default:
+		close(t.stop)
+	}
+	return nil
+}
+
+// pick returns a healthy endpoint, or nil if there are none
+func (t *BalancingTransport) pick(ctx context.Context) *endpoint {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.opts.Policy == ConsistentHash && t.opts.HashHeader != "" {
+		key := RequestHeaderFromContext(ctx).Get(t.opts.HashHeader)
+		if key != "" {
+			return t.pickHash(key)
+		}
+	}
+
+	n := len(t.endpoints)
+	start := int(atomic.AddUint32(&t.next, 1) % uint32(n))
+	var best *endpoint
+	for i := 0; i < n; i++ {
+		ep := t.endpoints[(start+i)%n]
+		if !ep.healthy {
+			continue
+		}
+		if t.opts.Policy != LeastInFlight {
+			return ep
+		}
+		if best == nil || atomic.LoadInt32(&ep.inFlight) < atomic.LoadInt32(&best.inFlight) {
+			best = ep
+		}
+	}
+	return best
+}
+
+// pickHash returns the first healthy endpoint at or after key on the ring.
+// t.mu must be held.
+func (t *BalancingTransport) pickHash(key string) *endpoint {
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(t.ring), func(i int) bool { return t.ring[i].hash >= h })
+	for j := 0; j < len(t.ring); j++ {
+		node := t.ring[(i+j)%len(t.ring)]
+		if node.ep.healthy {
+			return node.ep
+		}
+	}
+	return nil
+}
+
+func (t *BalancingTransport) markFailed(ep *endpoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ep.failures++
+	if ep.failures >= t.opts.MaxFailures {
+		ep.healthy = false
+	}
+}
+
+func (t *BalancingTransport) markHealthy(ep *endpoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ep.failures = 0
+	ep.healthy = true
+}
+
+// setEndpoints replaces the endpoints with the given URLs.  Endpoints that
+// were already known keep their state.
+func (t *BalancingTransport) setEndpoints(urls []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old := make(map[string]*endpoint, len(t.endpoints))
+	for _, ep := range t.endpoints {
+		old[ep.url] = ep
+	}
+
+	endpoints := make([]*endpoint, 0, len(urls))
+	ring := make([]ringNode, 0, len(urls)*ringReplicas)
+	for _, url := range urls {
+		ep, ok := old[url]
+		if !ok {
+			ep = &endpoint{url: url, trans: t.opts.NewTransport(url), healthy: true}
+		}
+		delete(old, url)
+		endpoints = append(endpoints, ep)
+
+		for i := 0; i < ringReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(url + "#" + strconv.Itoa(i)))
+			ring = append(ring, ringNode{h, ep})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	t.endpoints = endpoints
+	t.ring = ring
+}
+
+// probeLoop probes ejected endpoints and refreshes the endpoints from the
+// Resolver until Close is called
+func (t *BalancingTransport) probeLoop() {
+	probe := time.NewTicker(t.opts.ProbeInterval)
+	defer probe.Stop()
+
+	var resolve <-chan time.Time
+	if t.opts.Resolver != nil {
+		ticker := time.NewTicker(t.opts.ResolveInterval)
+		defer ticker.Stop()
+		resolve = ticker.C
+	}
+
+	for {
+		select {
+		case <-probe.C:
+			t.probe()
+		case <-resolve:
+			urls, err := t.opts.Resolver()
+			if err == nil && len(urls) > 0 {
+				t.setEndpoints(urls)
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// probe sends a barrister-idl request to each ejected endpoint, and
+// re-admits those that answer without error
+func (t *BalancingTransport) probe() {
+	t.mu.RLock()
+	ejected := make([]*endpoint, 0)
+	for _, ep := range t.endpoints {
+		if !ep.healthy {
+			ejected = append(ejected, ep)
+		}
+	}
+	t.mu.RUnlock()
+
+	for _, ep := range ejected {
+		ctx, cancel := context.WithTimeout(context.Background(), t.opts.ProbeInterval)
+		client := &RemoteClient{Trans: ep.trans, Ser: &JsonSerializer{}}
+		_, err := client.CallContext(ctx, "barrister-idl")
+		cancel()
+		if err == nil {
+			t.markHealthy(ep)
+		}
+	}
+}
@@ -2,18 +2,27 @@ package barrister
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var zeroVal reflect.Value
 
+var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+
 // randHex generates a random array of bytes and
 // returns the value as a hex encoded string
 func randHex(bytes int) string {
@@ -477,6 +486,55 @@ type JsonRpcRequest struct {
 
 	// Parameter values to be used during the invocation of the method
 	Params interface{} `json:"params"`
+
+	// If true and Method is a stream function, the server may reply with
+	// a sequence of StreamFrame messages instead of a single response
+	Stream bool `json:"stream,omitempty"`
+
+	// W3C trace context of the caller, for transports that cannot carry
+	// a traceparent header.  See Tracer.
+	Traceparent string `json:"traceparent,omitempty"`
+
+	// Idempotency key of the call, for transports that cannot carry an
+	// Idempotency-Key header.  See IdempotencyFilter.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// Milliseconds the caller will wait for the response, for transports
+	// that cannot carry a Barrister-Timeout header.  See Server.SetTimeout.
+	TimeoutMs int64 `json:"timeout_ms,omitempty"`
+}
+
+// reservedRequestHeaders returns headers with request headers taken from the
+// reserved members of rpcReq (Traceparent, IdempotencyKey and TimeoutMs),
+// unless headers already have them.  The request header map is copied, as it may be shared
+// by other requests.
+func reservedRequestHeaders(headers Headers, rpcReq *JsonRpcRequest) Headers {
+	reserved := map[string]string{
+		"Traceparent":        rpcReq.Traceparent,
+		IdempotencyKeyHeader: rpcReq.IdempotencyKey,
+	}
+	if rpcReq.TimeoutMs > 0 {
+		reserved[TimeoutHeader] = strconv.FormatInt(rpcReq.TimeoutMs, 10)
+	}
+
+	var req map[string][]string
+	for name, val := range reserved {
+		if val == "" || GetFirst(headers.Request, name) != "" {
+			continue
+		}
+		if req == nil {
+			req = make(map[string][]string, len(headers.Request)+len(reserved))
+			for k, v := range headers.Request {
+				req[k] = v
+			}
+		}
+		req[name] = []string{val}
+	}
+
+	if req != nil {
+		headers.Request = req
+	}
+	return headers
 }
 
 // JsonRpcError represents a JSON-RPC 2.0 Error
@@ -534,6 +592,11 @@ type RequestResponse struct {
 	// to JsonRpcResponse
 	Result interface{}
 	Err    error
+
+	// Carries request scoped values between Filters.  Filters may replace it
+	// with a derived context, e.g. to record when PreInvoke was called.
+	// After PreInvoke, it is returned to the handler by Headers.Context.
+	Context context.Context
 }
 
 // GetFirst returns the first value associated with the given
@@ -660,7 +723,69 @@ type Transport interface {
 	Send(in []byte) ([]byte, error)
 }
 
-// HttpTransport sends requests via the Go `http` package
+// ContextTransport is implemented by Transports that can bound a single
+// request by the context deadline, and abandon it when the context is
+// cancelled.
+type ContextTransport interface {
+	Transport
+	SendContext(ctx context.Context, in []byte) ([]byte, error)
+}
+
+type requestHeaderKey struct{}
+
+// WithRequestHeader returns a copy of ctx that carries the given request header
+// in addition to any headers ctx already carries.  HttpTransport adds these
+// headers to the HTTP request when the call is made with the returned context.
+func WithRequestHeader(ctx context.Context, name, value string) context.Context {
+	h := RequestHeaderFromContext(ctx).Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Add(name, value)
+	return context.WithValue(ctx, requestHeaderKey{}, h)
+}
+
+// setRequestHeader is like WithRequestHeader, but replaces any values of
+// the header that ctx already carries
+func setRequestHeader(ctx context.Context, name, value string) context.Context {
+	h := RequestHeaderFromContext(ctx).Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Set(name, value)
+	return context.WithValue(ctx, requestHeaderKey{}, h)
+}
+
+// RequestHeaderFromContext returns the request headers carried by ctx, or nil.
+// The returned header must not be modified.
+func RequestHeaderFromContext(ctx context.Context) http.Header {
+	h, _ := ctx.Value(requestHeaderKey{}).(http.Header)
+	return h
+}
+
+// DefaultHttpTimeout bounds each HttpTransport.Send call if neither
+// HttpTransport.Client nor HttpTransport.Timeout is set.
+const DefaultHttpTimeout = 30 * time.Second
+
+// DefaultRoundTripper is shared by all HttpTransports that do not specify
+// a Client or RoundTripper, so that connections to an endpoint are pooled
+// and reused across transports.
+var DefaultRoundTripper http.RoundTripper = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	MaxIdleConns:          256,
+	MaxIdleConnsPerHost:   64,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+// HttpTransport sends requests via the Go `http` package.
+// HttpTransport is safe for concurrent use, and should be reused
+// rather than created per request.
 type HttpTransport struct {
 	// Endpoint of JSON-RPC service to consume
 	Url string
@@ -668,8 +793,26 @@ type HttpTransport struct {
 	// Optional hook to invoke before/after requests
 	Hook HttpHook
 
-	// Optional CookieJar - useful if endpoint uses session cookies
+	// Optional CookieJar - useful if endpoint uses session cookies.
+	// Ignored if Client is set.
 	Jar http.CookieJar
+
+	// Optional http.Client used to send all requests.  If nil, a client
+	// using RoundTripper and Jar is created on first use.
+	Client *http.Client
+
+	// Optional RoundTripper used if Client is nil.
+	// Defaults to DefaultRoundTripper.
+	RoundTripper http.RoundTripper
+
+	// Optional timeout for each request.  If zero and Client is nil,
+	// DefaultHttpTimeout is used.  A negative value disables the timeout.
+	// Use RemoteClient.CallContext with a context deadline for shorter
+	// per-call timeouts.
+	Timeout time.Duration
+
+	initClient sync.Once
+	client     *http.Client
 }
 
 // HttpHook is an optional callback interface that can be implemented
@@ -685,44 +828,100 @@ type HttpHook interface {
 	After(req *http.Request, resp *http.Response, body []byte)
 }
 
+// Send posts the request, bounded by the Timeout
 func (t *HttpTransport) Send(in []byte) ([]byte, error) {
+	return t.SendContext(context.Background(), in)
+}
+
+// SendContext posts the request, bounded by the context deadline and the Timeout,
+// whichever is sooner
+func (t *HttpTransport) SendContext(ctx context.Context, in []byte) ([]byte, error) {
+	timeout := t.Timeout
+	if timeout == 0 && t.Client == nil {
+		timeout = DefaultHttpTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	req, err := http.NewRequest("POST", t.Url, bytes.NewBuffer(in))
+	req, resp, err := t.post(ctx, in, "")
 	if err != nil {
-		return nil, fmt.Errorf("barrister: HttpTransport NewRequest failed: %s", err)
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	// TODO: need to make mime type plugable
-	req.Header.Add("Content-Type", "application/json")
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("barrister: HttpTransport Unable to read resp.Body: %s", err)
+	}
 
 	if t.Hook != nil {
-		t.Hook.Before(req, in)
+		t.Hook.After(req, resp, body)
 	}
 
-	client := &http.Client{}
-	if t.Jar != nil {
-		client.Jar = t.Jar
+	return body, nil
+}
+
+// httpClient returns the client used to send requests
+func (t *HttpTransport) httpClient() *http.Client {
+	if t.Client != nil {
+		return t.Client
 	}
-	resp, err := client.Do(req)
+
+	t.initClient.Do(func() {
+		rt := t.RoundTripper
+		if rt == nil {
+			rt = DefaultRoundTripper
+		}
+		t.client = &http.Client{Transport: rt, Jar: t.Jar}
+	})
+	return t.client
+}
+
+// post sends in to t.Url and returns the response if it has a 2xx status.
+// If accept is not empty it is sent as the Accept header.
+// The caller must close the response body.
+func (t *HttpTransport) post(ctx context.Context, in []byte, accept string) (*http.Request, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", t.Url, bytes.NewBuffer(in))
 	if err != nil {
-		return nil, fmt.Errorf("barrister: HttpTransport POST to %s failed: %s", t.Url, err)
+		return nil, nil, fmt.Errorf("barrister: HttpTransport NewRequest failed: %s", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return nil, fmt.Errorf("barrister: HttpTransport POST to %s returned non-2xx status: %d - %s", t.Url, resp.StatusCode, resp.Status)
+	// TODO: need to make mime type plugable
+	req.Header.Add("Content-Type", "application/json")
+	if accept != "" {
+		req.Header.Add("Accept", accept)
+	}
+	for k, v := range RequestHeaderFromContext(ctx) {
+		req.Header[k] = append(req.Header[k], v...)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(TimeoutHeader, strconv.FormatInt(timeoutMillis(deadline), 10))
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	if t.Hook != nil {
+		t.Hook.Before(req, in)
+	}
+
+	resp, err := t.httpClient().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("barrister: HttpTransport Unable to read resp.Body: %s", err)
+		return nil, nil, fmt.Errorf("barrister: HttpTransport POST to %s failed: %s", t.Url, err)
 	}
 
-	if t.Hook != nil {
-		t.Hook.After(req, resp, body)
+	meta := ResponseMetaFromContext(ctx)
+	if meta != nil {
+		meta.Header = resp.Header
+		meta.StatusCode = resp.StatusCode
 	}
 
-	return body, nil
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("barrister: HttpTransport POST to %s returned non-2xx status: %d - %s", t.Url, resp.StatusCode, resp.Status)
+	}
+
+	return req, resp, nil
 }
 
 // Client abstracts methods for calling JSON-RPC services.  Note that the
@@ -736,18 +935,52 @@ type Client interface {
 	CallBatch(batch []JsonRpcRequest) []JsonRpcResponse
 }
 
+// ContextClient is implemented by Clients that can bound a call by a context
+// deadline, or abandon it when the context is cancelled.
+type ContextClient interface {
+	CallContext(ctx context.Context, method string, params ...interface{}) (interface{}, error)
+}
+
+// CallContext invokes method on c using ContextClient.CallContext if c
+// implements it, or Client.Call otherwise.
+//
+// For example, to bound a single call to one second:
+//
+//     ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+//     defer cancel()
+//     res, err := barrister.CallContext(ctx, client, "Calculator.add", 1, 2)
+//
+func CallContext(ctx context.Context, c Client, method string, params ...interface{}) (interface{}, error) {
+	cc, ok := c.(ContextClient)
+	if ok {
+		return cc.CallContext(ctx, method, params...)
+	}
+	return c.Call(method, params...)
+}
+
 // NewRemoteClient creates a RemoteClient with the given Transport using the JsonSerializer
 func NewRemoteClient(trans Transport, forceASCII bool) Client {
-	return &RemoteClient{trans, &JsonSerializer{forceASCII}}
+	return &RemoteClient{Trans: trans, Ser: &JsonSerializer{forceASCII}}
 }
 
 // RemoteClient implements Client against the given Transport and Serializer.
 type RemoteClient struct {
 	Trans Transport
 	Ser   Serializer
+
+	// Optional policy for retrying failed calls.  If nil, calls are not retried.
+	Retry *RetryPolicy
+
+	// Called around each Call.  See AddInterceptor
+	Interceptors []Interceptor
 }
 
 func (c *RemoteClient) CallBatch(batch []JsonRpcRequest) []JsonRpcResponse {
+	return c.CallBatchContext(context.Background(), batch)
+}
+
+// CallBatchContext sends a batch request bounded by the context
+func (c *RemoteClient) CallBatchContext(ctx context.Context, batch []JsonRpcRequest) []JsonRpcResponse {
 	reqBytes, err := c.Ser.Marshal(batch)
 	if err != nil {
 		msg := fmt.Sprintf("barrister: CallBatch unable to Marshal request: %s", err)
@@ -755,7 +988,7 @@ func (c *RemoteClient) CallBatch(batch []JsonRpcRequest) []JsonRpcResponse {
 			JsonRpcResponse{Error: &JsonRpcError{Code: -32600, Message: msg}}}
 	}
 
-	respBytes, err := c.Trans.Send(reqBytes)
+	respBytes, err := c.send(ctx, reqBytes)
 	if err != nil {
 		msg := fmt.Sprintf("barrister: CallBatch Transport error during request: %s", err)
 		return []JsonRpcResponse{
@@ -774,7 +1007,25 @@ func (c *RemoteClient) CallBatch(batch []JsonRpcRequest) []JsonRpcResponse {
 }
 
 func (c *RemoteClient) Call(method string, params ...interface{}) (interface{}, error) {
-	rpcReq := JsonRpcRequest{Jsonrpc: "2.0", Id: randHex(20), Method: method, Params: params}
+	return c.CallContext(context.Background(), method, params...)
+}
+
+// CallContext invokes a method bounded by the context.  If the Transport does
+// not implement ContextTransport, the call is abandoned (but not interrupted)
+// when the context is done.  Failed calls are retried according to c.Retry,
+// and the context bounds all attempts.
+func (c *RemoteClient) CallContext(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	return c.intercept(ctx, method, params, c.call)
+}
+
+// call marshals the request and sends it, retrying according to c.Retry
+func (c *RemoteClient) call(ctx context.Context, method string, params []interface{}) (interface{}, error) {
+	rpcReq := JsonRpcRequest{Jsonrpc: "2.0", Id: randHex(20), Method: method, Params: params,
+		Traceparent:    RequestHeaderFromContext(ctx).Get("Traceparent"),
+		IdempotencyKey: RequestHeaderFromContext(ctx).Get(IdempotencyKeyHeader)}
+	if deadline, ok := ctx.Deadline(); ok {
+		rpcReq.TimeoutMs = timeoutMillis(deadline)
+	}
 
 	reqBytes, err := c.Ser.Marshal(rpcReq)
 	if err != nil {
@@ -782,24 +1033,80 @@ func (c *RemoteClient) Call(method string, params ...interface{}) (interface{},
 		return nil, &JsonRpcError{Code: -32600, Message: msg}
 	}
 
-	respBytes, err := c.Trans.Send(reqBytes)
+	for attempt := 1; ; attempt++ {
+		result, rpcErr, transportErr := c.callOnce(ctx, method, reqBytes)
+		if rpcErr == nil {
+			return result, nil
+		}
+		if !c.Retry.shouldRetry(method, rpcReq.IdempotencyKey != "", attempt, rpcErr, transportErr) ||
+			!c.Retry.wait(ctx, attempt) {
+			return nil, rpcErr
+		}
+	}
+}
+
+// callOnce sends a serialized request and decodes the response.  If the
+// Transport failed, transportErr is true.  Transport errors are returned as
+// code -32603, unless the Transport returned a *JsonRpcError.
+func (c *RemoteClient) callOnce(ctx context.Context, method string, reqBytes []byte) (result interface{}, rpcErr *JsonRpcError, transportErr bool) {
+	respBytes, err := c.send(ctx, reqBytes)
 	if err != nil {
-		msg := fmt.Sprintf("barrister: %s: Transport error during request: %s", method, err)
-		return nil, &JsonRpcError{Code: -32603, Message: msg}
+		e, ok := err.(*JsonRpcError)
+		if !ok {
+			msg := fmt.Sprintf("barrister: %s: Transport error during request: %s", method, err)
+			e = &JsonRpcError{Code: -32603, Message: msg}
+		}
+		return nil, e, true
 	}
 
 	var rpcResp JsonRpcResponse
 	err = c.Ser.Unmarshal(respBytes, &rpcResp)
 	if err != nil {
 		msg := fmt.Sprintf("barrister: %s: Call unable to Unmarshal response: %s", method, err)
-		return nil, &JsonRpcError{Code: -32603, Message: msg}
+		return nil, &JsonRpcError{Code: -32603, Message: msg}, false
 	}
 
 	if rpcResp.Error != nil {
-		return nil, rpcResp.Error
+		return nil, rpcResp.Error, false
+	}
+
+	return rpcResp.Result, nil, false
+}
+
+// send passes the request to the Transport, honoring ctx
+func (c *RemoteClient) send(ctx context.Context, in []byte) ([]byte, error) {
+	return sendContext(ctx, c.Trans, in)
+}
+
+// sendContext passes the request to trans.  If trans does not implement
+// ContextTransport, the request is abandoned when ctx is done.
+func sendContext(ctx context.Context, trans Transport, in []byte) ([]byte, error) {
+	ct, ok := trans.(ContextTransport)
+	if ok {
+		return ct.SendContext(ctx, in)
+	}
+
+	if ctx.Done() == nil {
+		// context can never be cancelled
+		return trans.Send(in)
+	}
+
+	type sendResult struct {
+		out []byte
+		err error
 	}
+	ch := make(chan sendResult, 1)
+	go func() {
+		out, err := trans.Send(in)
+		ch <- sendResult{out, err}
+	}()
 
-	return rpcResp.Result, nil
+	select {
+	case r := <-ch:
+		return r.out, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 //////////////////////////////////////////////////
@@ -840,6 +1147,74 @@ type Headers struct {
 	// Transport implementations are responsible for
 	// sending values in this map back to the caller.
 	Response map[string][]string
+
+	// Raw request body, or the request line for requests read by
+	// Server.ServeConn.  Used to verify signed requests.  Read-only.
+	Body []byte
+
+	// TLS connection state - only set if the request was received over TLS.
+	// Read-only.
+	TLS *tls.ConnectionState
+
+	// Network address of the caller, if known.  e.g. "10.0.0.1:52413"
+	RemoteAddr string
+
+	// context of the transport request, if any.  Set by ServeHTTP
+	ctx context.Context
+
+	// state of a single call, shared by the filters and handler of the
+	// call.  Set by Server.call
+	call *callState
+}
+
+// callState holds values that filters set for the handler of a call
+type callState struct {
+	principal *Principal
+
+	// done when the call times out or is canceled.  See Headers.Context
+	ctx context.Context
+
+	// funcs called when the call returns, e.g. to release resources
+	// acquired by a filter.  Called in reverse order.
+	onDone []func()
+
+	// error returned by the call, set before the onDone funcs are called.
+	// Filters read it from onDone to observe calls that a later Filter
+	// rejected in PreInvoke.
+	err error
+}
+
+// done sets the error returned by the call and calls the onDone funcs
+func (cs *callState) done(err error) {
+	cs.err = err
+	for i := len(cs.onDone) - 1; i >= 0; i-- {
+		cs.onDone[i]()
+	}
+}
+
+// Principal returns the identity of the caller established by an
+// AuthFilter, or nil if the call was not authenticated.  Handlers that
+// implement Cloneable may keep the Headers passed to CloneForReq and call
+// Principal from their methods.
+func (me *Headers) Principal() *Principal {
+	if me.call == nil {
+		return nil
+	}
+	return me.call.principal
+}
+
+// Context returns the context of the call, which is done when the call
+// times out (see Server.SetTimeout) or the caller goes away.  Handlers that
+// implement Cloneable may keep the Headers passed to CloneForReq and pass
+// the context to the APIs they call.
+func (me *Headers) Context() context.Context {
+	if me.call != nil && me.call.ctx != nil {
+		return me.call.ctx
+	}
+	if me.ctx != nil {
+		return me.ctx
+	}
+	return context.Background()
 }
 
 // GetCookie returns the cookie associated with the given
@@ -926,7 +1301,9 @@ func NewJSONServer(idl *Idl, forceASCII bool) Server {
 
 // NewServer creates a Server for the given IDL and Serializer
 func NewServer(idl *Idl, ser Serializer) Server {
-	return Server{idl, ser, map[string]interface{}{}, make([]Filter, 0)}
+	return Server{idl: idl, ser: ser, handlers: map[string]interface{}{}, filters: make([]Filter, 0),
+		events: newEventHub(), methodTimeouts: map[string]time.Duration{}, payloadLimits: DefaultPayloadLimits,
+		drain: &drainState{}, health: newHealthState(), info: newInfoState()}
 }
 
 // Server represents a handler for Barrister IDL file.
@@ -937,6 +1314,27 @@ type Server struct {
 	ser      Serializer
 	handlers map[string]interface{}
 	filters  []Filter
+	events   *eventHub
+
+	// see SetPanicHandler and SetDebug
+	panicHandler func(p *PanicInfo)
+	debug        bool
+
+	// see SetTimeout and SetMethodTimeout
+	timeout        time.Duration
+	methodTimeouts map[string]time.Duration
+
+	// see SetPayloadLimits
+	payloadLimits PayloadLimits
+
+	// see Shutdown
+	drain *drainState
+
+	// see AddHealthCheck
+	health *healthState
+
+	// see EnableInfo
+	info *infoState
 }
 
 // AddFilter registers a Filter implementation with the Server.
@@ -960,10 +1358,13 @@ func (s *Server) AddHandler(iface string, impl interface{}) {
 		panic(msg)
 	}
 
-	var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
-
 	elem := reflect.ValueOf(impl)
 	for _, idlFunc := range ifaceFuncs {
+		if idlFunc.IsEvent() {
+			// events are published by the server, not implemented by handlers
+			continue
+		}
+
 		fname := capitalize(idlFunc.Name)
 		fn := elem.MethodByName(fname)
 		if fn == zeroVal {
@@ -973,6 +1374,11 @@ func (s *Server) AddHandler(iface string, impl interface{}) {
 		}
 
 		fnType := fn.Type()
+		if idlFunc.IsStream() {
+			s.validateStream(iface, fname, idlFunc, fnType)
+			continue
+		}
+
 		if fnType.NumIn() != len(idlFunc.Params) {
 			msg := fmt.Sprintf("barrister: %s impl method: %s accepts %d params but IDL specifies %d", iface, fname, fnType.NumIn(), len(idlFunc.Params))
 			panic(msg)
@@ -1016,6 +1422,38 @@ func (s *Server) validate(idlField Field, implType reflect.Type, path string) {
 	}
 }
 
+// validateStream ensures that the handler method for a stream function accepts
+// the IDL params followed by a sender func, and returns only an error.
+// For example: `Repeat_num(num int64, count int64, out func(item int64) error) error`
+//
+// validateStream panics if the method does not match.
+func (s *Server) validateStream(iface string, fname string, idlFunc Function, fnType reflect.Type) {
+	numParams := len(idlFunc.Params)
+	if fnType.NumIn() != numParams+1 {
+		msg := fmt.Sprintf("barrister: %s impl method: %s accepts %d params but IDL stream function requires %d", iface, fname, fnType.NumIn(), numParams+1)
+		panic(msg)
+	}
+
+	if fnType.NumOut() != 1 || fnType.Out(0) != typeOfError {
+		msg := fmt.Sprintf("barrister: %s impl method: %s must return a single error", iface, fname)
+		panic(msg)
+	}
+
+	for x, param := range idlFunc.Params {
+		path := fmt.Sprintf("%s.%s param[%d]", iface, fname, x)
+		s.validate(param, fnType.In(x), path)
+	}
+
+	sender := fnType.In(numParams)
+	if sender.Kind() != reflect.Func || sender.NumIn() != 1 || sender.NumOut() != 1 || sender.Out(0) != typeOfError {
+		msg := fmt.Sprintf("%s.%s param[%d] has invalid type: %s (expected: func(item) error)", iface, fname, numParams, sender)
+		panic(msg)
+	}
+
+	path := fmt.Sprintf("%s.%s stream item", iface, fname)
+	s.validate(idlFunc.streamItem(), sender.In(0), path)
+}
+
 // InvokeBytes handles a raw request.  It unmarhals the request based on the
 // registered Serializer (e.g. the JsonSerializer) and then determines if the
 // request is a single or batch call.
@@ -1035,9 +1473,30 @@ func (s *Server) InvokeBytes(headers Headers, req []byte) []byte {
 		if err != nil {
 			return jsonParseErr("", true, err)
 		}
+		max := s.payloadLimits.MaxBatchLength
+		if max > 0 && len(batchReq) > max {
+			// each element fails, so clients can match the errors by id
+			rpcErr := &JsonRpcError{Code: -32600,
+				Message: fmt.Sprintf("barrister: batch of %d requests exceeds maximum of %d", len(batchReq), max)}
+			for _, req := range batchReq {
+				batchResp = append(batchResp, JsonRpcResponse{Jsonrpc: "2.0", Id: req.Id, Error: rpcErr})
+			}
+			b, err := s.ser.Marshal(batchResp)
+			if err != nil {
+				panic(err)
+			}
+			return b
+		}
 
 		for _, req := range batchReq {
 			resp := s.InvokeOne(headers, &req)
+
+			// a result that cannot be marshaled fails only its own element
+			_, err := s.ser.Marshal(resp)
+			if err != nil {
+				msg := fmt.Sprintf("barrister: %s: Unable to Marshal response: %s", req.Method, err)
+				resp = &JsonRpcResponse{Jsonrpc: "2.0", Id: req.Id, Error: &JsonRpcError{Code: -32603, Message: msg}}
+			}
 			batchResp = append(batchResp, *resp)
 		}
 
@@ -1055,8 +1514,25 @@ func (s *Server) InvokeBytes(headers Headers, req []byte) []byte {
 		return jsonParseErr("", false, err)
 	}
 
-	resp := s.InvokeOne(headers, &rpcReq)
+	return s.invokeOneBytes(headers, &rpcReq)
+}
+
+// invokeOneBytes delegates to InvokeOne and marshals the response
+func (s *Server) invokeOneBytes(headers Headers, rpcReq *JsonRpcRequest) []byte {
+	resp := s.InvokeOne(headers, rpcReq)
+
+	b, err := s.ser.Marshal(resp)
+	if err != nil {
+		return s.marshalErr(rpcReq.Method, rpcReq.Id, err)
+	}
+	return b
+}
 
+// marshalErr returns a serialized -32603 error response for a result
+// that could not be marshaled
+func (s *Server) marshalErr(method string, id string, err error) []byte {
+	msg := fmt.Sprintf("barrister: %s: Unable to Marshal response: %s", method, err)
+	resp := JsonRpcResponse{Jsonrpc: "2.0", Id: id, Error: &JsonRpcError{Code: -32603, Message: msg}}
 	b, err := s.ser.Marshal(resp)
 	if err != nil {
 		panic(err)
@@ -1064,13 +1540,27 @@ func (s *Server) InvokeBytes(headers Headers, req []byte) []byte {
 	return b
 }
 
+// errorBytes returns a serialized error response without an id
+func (s *Server) errorBytes(rpcErr *JsonRpcError) []byte {
+	b, err := s.ser.Marshal(JsonRpcResponse{Jsonrpc: "2.0", Error: rpcErr})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
 // InvokeOne handles a single JSON-RPC request, delegating to Call.  If the special "barrister-idl"
-// method is handled, InvokeOne will return the IDL associated with this Server.
+// method is handled, InvokeOne will return the IDL associated with this Server.  The special
+// "barrister-health" method returns the Server's HealthStatus.  Neither is passed to Filters.
 func (s *Server) InvokeOne(headers Headers, rpcReq *JsonRpcRequest) *JsonRpcResponse {
 	if rpcReq.Method == "barrister-idl" {
 		// handle 'barrister-idl' method
 		return &JsonRpcResponse{Jsonrpc: "2.0", Id: rpcReq.Id, Result: s.idl.elems}
 	}
+	if rpcReq.Method == "barrister-health" {
+		return s.invokeHealth(headers, rpcReq)
+	}
+	headers = reservedRequestHeaders(headers, rpcReq)
 
 	// handle normal RPC method executions
 	var result interface{}
@@ -1129,17 +1619,50 @@ func (s *Server) CallBatch(headers Headers, batch []JsonRpcRequest) []JsonRpcRes
 // 6) The handler function is invoked
 //
 // 7) If the Server has one or more Filters registered, PostInvoke() will be called on each Filter.  Filters are
-// called in the reverse order.  If any Filter returns false, filter execution will stop.
+// called in the reverse order.  If any Filter returns false, filter execution will stop.  PostInvoke is called
+// for every call that passed PreInvoke, including calls whose params fail validation in step 5 and calls whose
+// handler panics, with RequestResponse.Err set to the error.
 //
 // 8) The result/error is returned
 //
+// If the method is a stream function, the streamed items are collected into a
+// slice which is returned as the result.  Use CallStream to receive the items
+// as they are produced.
+//
 func (s *Server) Call(headers Headers, method string, params ...interface{}) (interface{}, error) {
+	return s.call(headers, method, params, nil)
+}
+
+// call implements Call and CallStream.  send is only used if the method
+// is a stream function.
+func (s *Server) call(headers Headers, method string, params []interface{}, send func(item interface{}) error) (result interface{}, err error) {
+	// the call is tracked until its handler returns, even if it is
+	// abandoned after a timeout (see invokeHandler)
+	var rel releaser
+	defer rel.run()
+	track := s.info.track(method)
+	rel.add(func() { track(&err) })
+	defer s.recoverPanic(method, &result, &err)
+
+	if !s.drain.enter() {
+		return nil, shutdownError()
+	}
+	rel.add(s.drain.leave)
+
+	if strings.HasPrefix(method, InfoInterface+".") {
+		return s.callInfo(headers, method, params)
+	}
 
 	idlFunc, ok := s.idl.methods[method]
 	if !ok {
 		return nil, &JsonRpcError{Code: -32601, Message: fmt.Sprintf("Unsupported method: %s", method)}
 	}
 
+	if idlFunc.IsEvent() {
+		return nil, &JsonRpcError{Code: -32601,
+			Message: fmt.Sprintf("Method %s is an event and must be subscribed to", method)}
+	}
+
 	iface, fname := parseMethod(method)
 
 	handler, ok := s.handlers[iface]
@@ -1148,6 +1671,12 @@ func (s *Server) Call(headers Headers, method string, params ...interface{}) (in
 			Message: fmt.Sprintf("No handler registered for interface: %s", iface)}
 	}
 
+	ctx, timeout, cancel := s.callContext(headers, method)
+	defer cancel()
+	headers.call = &callState{ctx: ctx}
+	call := headers.call
+	rel.add(func() { call.done(err) })
+
 	// If handler supports cloning, create a new instance for this request
 	c, ok := handler.(Cloneable)
 	if ok {
@@ -1165,9 +1694,14 @@ func (s *Server) Call(headers Headers, method string, params ...interface{}) (in
 
 	// check params
 	fnType := fn.Type()
-	if fnType.NumIn() != len(params) {
+	numIn := fnType.NumIn()
+	if idlFunc.IsStream() {
+		// last argument of a stream function is the sender
+		numIn--
+	}
+	if numIn != len(params) {
 		return nil, &JsonRpcError{Code: -32602,
-			Message: fmt.Sprintf("Method %s expects %d params but was passed %d", method, fnType.NumIn(), len(params))}
+			Message: fmt.Sprintf("Method %s expects %d params but was passed %d", method, numIn, len(params))}
 	}
 
 	if len(idlFunc.Params) != len(params) {
@@ -1175,7 +1709,8 @@ func (s *Server) Call(headers Headers, method string, params ...interface{}) (in
 			Message: fmt.Sprintf("Method %s expects %d params but was passed %d", method, len(idlFunc.Params), len(params))}
 	}
 
-	rr := &RequestResponse{headers, method, params, handler, nil, nil}
+	rr := &RequestResponse{Headers: headers, Method: method, Params: params, Handler: handler,
+		Context: ctx}
 
 	// run filters - PreInvoke
 	flen := len(s.filters)
@@ -1186,6 +1721,35 @@ func (s *Server) Call(headers Headers, method string, params ...interface{}) (in
 		}
 	}
 
+	// the handler sees the context as the filters left it, e.g. with the
+	// server span of a Tracer
+	if rr.Context != nil {
+		headers.call.ctx = rr.Context
+	}
+
+	rr.Result, rr.Err = s.invoke(ctx, timeout, method, idlFunc, fn, params, send, &rel)
+
+	// run filters - PostInvoke
+	for i := flen - 1; i >= 0; i-- {
+		ok := s.filters[i].PostInvoke(rr)
+		if !ok {
+			break
+		}
+	}
+
+	return rr.Result, rr.Err
+}
+
+// invoke converts the params of a call that passed the PreInvoke filters and
+// calls the handler.  A panic is returned as a -32603 error, so that the
+// PostInvoke filters see every outcome of the call.
+func (s *Server) invoke(ctx context.Context, timeout time.Duration, method string, idlFunc Function,
+	fn reflect.Value, params []interface{}, send func(item interface{}) error, rel *releaser) (result interface{}, err error) {
+	defer s.recoverPanic(method, &result, &err)
+
+	fnType := fn.Type()
+	numIn := len(params)
+
 	// convert params
 	paramVals := []reflect.Value{}
 	for x, param := range params {
@@ -1193,58 +1757,131 @@ func (s *Server) Call(headers Headers, method string, params ...interface{}) (in
 		idlField := idlFunc.Params[x]
 		path := fmt.Sprintf("param[%d]", x)
 		paramConv := newConvert(s.idl, &idlField, desiredType, param, path)
+		paramConv.limits = &s.payloadLimits
 		converted, err := paramConv.run()
-		if err != nil {
+		if _, ok := err.(*limitError); ok {
+			return nil, &JsonRpcError{Code: -32600, Message: err.Error()}
+		} else if err != nil {
 			return nil, &JsonRpcError{Code: -32602, Message: err.Error()}
 		}
 		paramVals = append(paramVals, converted)
 	}
 
 	// make the call
-	ret := fn.Call(paramVals)
-	if len(ret) != 2 {
-		msg := fmt.Sprintf("Method %s did not return 2 values. len(ret)=%d", method, len(ret))
-		return nil, &JsonRpcError{Code: -32603, Message: msg}
-	}
+	var ret0, ret1 interface{}
+	var callErr error
+	if idlFunc.IsStream() {
+		senderType := fnType.In(numIn)
+		items := zeroVal
+		if send == nil {
+			items = reflect.MakeSlice(reflect.SliceOf(senderType.In(0)), 0, 0)
+			send = func(item interface{}) error {
+				items = reflect.Append(items, reflect.ValueOf(item))
+				return nil
+			}
+		}
+		send, closeSend := guardSend(ctx, send)
+		paramVals = append(paramVals, newSender(senderType, send))
 
-	ret0 := ret[0].Interface()
-	ret1 := ret[1].Interface()
+		ret, panicked, err := s.invokeHandler(ctx, timeout, method, fn, paramVals, closeSend, rel)
+		if panicked != nil {
+			return nil, panicked
+		}
+		if err != nil {
+			callErr = err
+		} else if len(ret) != 1 {
+			msg := fmt.Sprintf("Method %s did not return 1 value. len(ret)=%d", method, len(ret))
+			return nil, &JsonRpcError{Code: -32603, Message: msg}
+		} else {
+			if items.IsValid() {
+				ret0 = items.Interface()
+			}
+			ret1 = ret[0].Interface()
+		}
+	} else {
+		ret, panicked, err := s.invokeHandler(ctx, timeout, method, fn, paramVals, func() {}, rel)
+		if panicked != nil {
+			return nil, panicked
+		}
+		if err != nil {
+			callErr = err
+		} else if len(ret) != 2 {
+			msg := fmt.Sprintf("Method %s did not return 2 values. len(ret)=%d", method, len(ret))
+			return nil, &JsonRpcError{Code: -32603, Message: msg}
+		} else {
+			ret0 = ret[0].Interface()
+			ret1 = ret[1].Interface()
+		}
+	}
 
-	rr.Result = ret0
+	err = callErr
 	if ret1 != nil {
 		e, ok := ret1.(error)
 		if ok {
-			rr.Err = e
-		}
-	}
-
-	// run filters - PostInvoke
-	for i := flen - 1; i >= 0; i-- {
-		ok := s.filters[i].PostInvoke(rr)
-		if !ok {
-			break
+			err = e
 		}
 	}
-
-	return rr.Result, rr.Err
+	return ret0, err
 }
 
 // ServeHTTP handles HTTP requests for the server.
+//
+// Requests for stream functions that set JsonRpcRequest.Stream are answered
+// with a chunked response containing one StreamFrame per line.
 func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.drain.isClosing() {
+		w.Header().Set("Content-Type", s.ser.MimeType())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(s.errorBytes(shutdownError()))
+		return
+	}
+
+	body := req.Body
+	if s.payloadLimits.MaxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, body, s.payloadLimits.MaxBodyBytes)
+	}
+
 	buf := bytes.Buffer{}
-	_, err := buf.ReadFrom(req.Body)
+	_, err := buf.ReadFrom(body)
 	if err != nil {
-		// TODO: log and return JSON-RPC response?
-		panic(err)
+		status, msg := http.StatusBadRequest, fmt.Sprintf("barrister: Unable to read request body: %s", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status, msg = http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("barrister: request body exceeds %d bytes", tooLarge.Limit)
+		}
+		w.Header().Set("Content-Type", s.ser.MimeType())
+		w.WriteHeader(status)
+		w.Write(s.errorBytes(&JsonRpcError{Code: -32600, Message: msg}))
+		return
 	}
 
 	headers := Headers{
-		Request:  req.Header,
-		Cookies:  req.Cookies(),
-		Response: make(map[string][]string),
+		Request:    req.Header,
+		Cookies:    req.Cookies(),
+		Response:   make(map[string][]string),
+		Body:       buf.Bytes(),
+		TLS:        req.TLS,
+		RemoteAddr: req.RemoteAddr,
+		ctx:        req.Context(),
+	}
+
+	var resp []byte
+	if s.ser.IsBatch(buf.Bytes()) {
+		resp = s.InvokeBytes(headers, buf.Bytes())
+	} else {
+		rpcReq := JsonRpcRequest{}
+		err := s.ser.Unmarshal(buf.Bytes(), &rpcReq)
+		if err != nil {
+			resp = jsonParseErr("", false, err)
+		} else if s.isStreamReq(&rpcReq) {
+			s.serveStream(w, headers, &rpcReq)
+			return
+		} else {
+			resp = s.invokeOneBytes(headers, &rpcReq)
+		}
 	}
 
-	resp := s.InvokeBytes(headers, buf.Bytes())
 	w.Header().Set("Content-Type", s.ser.MimeType())
 
 	for k, v := range headers.Response {
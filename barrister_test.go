@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -80,6 +81,71 @@ func TestIdl2Go(t *testing.T) {
 	}
 }
 
+func TestIdl2GoComments(t *testing.T) {
+	idl := MustParseIdlJson([]byte(`[{
+	    "type": "enum", "name": "Color", "comment": "Paint colors",
+	    "values": [{"value": "red", "comment": ""}, {"value": "blue", "comment": "@deprecated"}]
+	}, {
+	    "type": "struct", "name": "Account", "comment": "", "extends": "",
+	    "fields": [{"name": "email", "type": "string", "optional": false, "is_array": false,
+	                "comment": "primary address\n@deprecated use emails instead"}]
+	}, {
+	    "type": "interface", "name": "Accounts", "comment": "Manages accounts",
+	    "functions": [{
+	        "name": "get", "comment": "loads an account\n@idempotent\n@roles admin",
+	        "params": [], "returns": {"name": "", "type": "Account", "optional": false, "is_array": false, "comment": ""}
+	    }, {
+	        "name": "changed", "comment": "@event",
+	        "params": [], "returns": {"name": "", "type": "Account", "optional": false, "is_array": false, "comment": ""}
+	    }]
+	}]`))
+	code := string(idl.GenerateGo("accounts", "", false)["accounts"])
+
+	expected := []string{
+		"// Color is generated from IDL enum Color.\n//\n// Paint colors\ntype Color string",
+		"\t// ColorBlue is generated from IDL enum value Color.blue.\n\t//\n\t// Deprecated: Color.blue is deprecated.\n",
+		"// Account is generated from IDL struct Account.\ntype Account struct {",
+		"\t// Email is generated from IDL field Account.email.\n\t//\n\t// primary address\n\t//\n" +
+			"\t// Deprecated: use emails instead\n\tEmail\t",
+		"// Accounts is generated from IDL interface Accounts.\n//\n// Manages accounts\ntype Accounts interface {",
+		"\t// Get is generated from IDL function Accounts.get.\n\t//\n\t// loads an account\n\tGet() (",
+		"// AccountsProxy implements Accounts by calling IDL interface Accounts on a\n",
+		"// Get is generated from IDL function Accounts.get.\n//\n// loads an account\nfunc (_p AccountsProxy) Get() (",
+		"// SubscribeChanged is generated from IDL event Accounts.changed.\nfunc (_p AccountsProxy) SubscribeChanged(",
+		"// UnsubscribeChanged cancels a subscription to IDL event Accounts.changed.\n",
+		"// NewAccountsProxy returns an AccountsProxy that calls the server through c.\n" +
+			"func NewAccountsProxy(c barrister.Client) AccountsProxy {",
+		"// remote server.\n// Events are subscribed to with methods that are not part of Accounts,\n// e.g. SubscribeChanged.\n",
+	}
+	for _, s := range expected {
+		if !strings.Contains(code, s) {
+			t.Errorf("generated code does not contain: %q", s)
+		}
+	}
+	if strings.Contains(code, "// @") {
+		t.Errorf("annotations in generated comments:\n%s", code)
+	}
+}
+
+func TestIdl2GoProxyStreamMethods(t *testing.T) {
+	idl := MustParseIdlJson([]byte(streamIdlJson))
+	code := string(idl.GenerateGo("counter", "", false)["counter"])
+
+	// the constructor returns the proxy, whose Stream methods are not part
+	// of the interface
+	expected := []string{
+		"// NewCounterProxy returns a CounterProxy that calls the server through c.\n" +
+			"func NewCounterProxy(c barrister.Client) CounterProxy {",
+		"func (_p CounterProxy) CountStream(",
+		"// Stream functions also have a method ending in \"Stream\" that\n// returns an iterator, e.g. CountStream.\n",
+	}
+	for _, s := range expected {
+		if !strings.Contains(code, s) {
+			t.Errorf("generated code does not contain: %q\n%s", s, code)
+		}
+	}
+}
+
 func TestParseMethod(t *testing.T) {
 	cases := [][]string{
 		[]string{"B.echo", "B", "Echo"},
@@ -151,6 +217,23 @@ func TestConvert(t *testing.T) {
 	}
 }
 
+func TestConvertSliceOfPointers(t *testing.T) {
+	idl := createTestIdl()
+	field := &Field{Type: "NoNesting", Optional: true, IsArray: true}
+
+	// each element is converted to *NoNesting, so a *NoNesting after a
+	// converted map is not compared to NoNesting
+	input := []interface{}{map[string]interface{}{"a": "x"}, &NoNesting{A: "y"}, map[string]interface{}{"a": "z"}}
+	val, err := newConvert(idl, field, reflect.TypeOf([]*NoNesting{}), input, "p").run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, ok := val.Interface().([]*NoNesting)
+	if !ok || len(out) != 3 || out[0].A != "x" || out[1].A != "y" || out[2].A != "z" {
+		t.Errorf("unexpected result: %#v", val.Interface())
+	}
+}
+
 func TestAddHandlerPanicsIfIfaceNotInIdl(t *testing.T) {
 	idl := createTestIdl()
 	svr := NewJSONServer(idl, true)
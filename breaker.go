@@ -0,0 +1,320 @@
+package barrister
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCodeCircuitOpen is the JsonRpcError code returned for calls rejected
+// by a CircuitBreaker
+const ErrCodeCircuitOpen = -32099
+
+// CircuitState is the state of a single circuit of a CircuitBreaker
+type CircuitState int
+
+const (
+	// CircuitClosed allows all calls
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen rejects all calls until BreakerOptions.OpenTimeout elapses
+	CircuitOpen
+
+	// CircuitHalfOpen allows a limited number of trial calls.  If they
+	// succeed the circuit closes, otherwise it opens again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// BreakerOptions configure a CircuitBreaker
+type BreakerOptions struct {
+	// Period over which failures are counted.  Defaults to 10s.
+	Window time.Duration
+
+	// Minimum number of calls in a Window before the circuit may open.
+	// Defaults to 20.
+	MinRequests int
+
+	// Fraction of failed calls in a Window that opens the circuit.
+	// Defaults to 0.5.
+	FailureRatio float64
+
+	// How long an open circuit rejects calls before allowing trial calls.
+	// Defaults to 30s.
+	OpenTimeout time.Duration
+
+	// Number of concurrent trial calls allowed while half-open.  Defaults to 1.
+	HalfOpenRequests int
+
+	// Optional func that decides which errors count as failures.  By default
+	// Transport errors and JsonRpcErrors with code -32603 are failures, while
+	// errors returned by the service's handlers are not.  Calls through a
+	// wrapped Transport or Client that fail after their context is done are
+	// not recorded, as the caller gave up on them.
+	IsFailure func(err error) bool
+
+	// Optional func called after a circuit changes state.  name is the
+	// endpoint name or method of the circuit.
+	OnStateChange func(name string, from, to CircuitState)
+}
+
+// CircuitBreaker stops calls to a degraded service from piling up.  Each
+// CircuitBreaker tracks a separate circuit per name: an endpoint name for
+// wrapped Transports, or a method for wrapped Clients.  When the failure ratio
+// of a circuit reaches BreakerOptions.FailureRatio the circuit opens, and calls
+// fail immediately with code ErrCodeCircuitOpen.
+//
+// To break per endpoint of a BalancingTransport, wrap each endpoint:
+//
+//     cb := barrister.NewCircuitBreaker(barrister.BreakerOptions{})
+//     opts := barrister.BalanceOptions{
+//         Endpoints: urls,
+//         NewTransport: func(url string) barrister.Transport {
+//             return cb.WrapTransport(url, &barrister.HttpTransport{Url: url})
+//         },
+//     }
+//
+// To break per method, wrap the Client:
+//
+//     client := cb.WrapClient(barrister.NewRemoteClient(trans, true))
+//
+type CircuitBreaker struct {
+	opts BreakerOptions
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit is the state of a single name.  Guarded by CircuitBreaker.mu.
+type circuit struct {
+	state       CircuitState
+	windowStart time.Time
+	total       int
+	failures    int
+	openedAt    time.Time
+	trials      int
+}
+
+// NewCircuitBreaker creates a CircuitBreaker with all circuits closed
+func NewCircuitBreaker(opts BreakerOptions) *CircuitBreaker {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 20
+	}
+	if opts.FailureRatio <= 0 {
+		opts.FailureRatio = 0.5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	if opts.HalfOpenRequests <= 0 {
+		opts.HalfOpenRequests = 1
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = isTransportFailure
+	}
+	return &CircuitBreaker{opts: opts, circuits: map[string]*circuit{}}
+}
+
+// isTransportFailure is the default BreakerOptions.IsFailure
+func isTransportFailure(err error) bool {
+	e, ok := err.(*JsonRpcError)
+	return !ok || e.Code == -32603 || e.Code == ErrCodeCircuitOpen
+}
+
+// State returns the current state of the named circuit
+func (b *CircuitBreaker) State(name string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[name]
+	if !ok {
+		return CircuitClosed
+	}
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= b.opts.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// Do calls fn if the named circuit allows it, and records the result.  If the
+// circuit is open, fn is not called and a JsonRpcError with code
+// ErrCodeCircuitOpen is returned.
+func (b *CircuitBreaker) Do(name string, fn func() error) error {
+	return b.do(context.Background(), name, fn)
+}
+
+// do is Do for a call made with ctx.  If fn fails after ctx is done, the
+// result is not recorded.
+func (b *CircuitBreaker) do(ctx context.Context, name string, fn func() error) error {
+	err := b.allow(name)
+	if err != nil {
+		return err
+	}
+	err = fn()
+	if err != nil && ctx.Err() != nil {
+		b.abandon(name)
+		return err
+	}
+	b.record(name, err == nil || !b.opts.IsFailure(err))
+	return err
+}
+
+// WrapTransport returns a Transport that sends requests with trans, through
+// the circuit with the given name
+func (b *CircuitBreaker) WrapTransport(name string, trans Transport) Transport {
+	return &breakerTransport{b, name, trans}
+}
+
+// WrapClient returns a Client that makes calls with c, through a circuit
+// per method.  Batch calls are passed to c without a circuit.
+func (b *CircuitBreaker) WrapClient(c Client) Client {
+	return &breakerClient{b, c}
+}
+
+func (b *CircuitBreaker) allow(name string) error {
+	var from, to CircuitState
+	var rejected bool
+
+	b.mu.Lock()
+	c, ok := b.circuits[name]
+	if !ok {
+		c = &circuit{windowStart: time.Now()}
+		b.circuits[name] = c
+	}
+
+	from = c.state
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= b.opts.OpenTimeout {
+		c.state = CircuitHalfOpen
+		c.trials = 0
+	}
+	switch c.state {
+	case CircuitOpen:
+		rejected = true
+	case CircuitHalfOpen:
+		if c.trials >= b.opts.HalfOpenRequests {
+			rejected = true
+		} else {
+			c.trials++
+		}
+	}
+	to = c.state
+	b.mu.Unlock()
+
+	b.changed(name, from, to)
+	if rejected {
+		msg := fmt.Sprintf("barrister: %s: circuit open", name)
+		return &JsonRpcError{Code: ErrCodeCircuitOpen, Message: msg}
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) record(name string, success bool) {
+	b.mu.Lock()
+	c := b.circuits[name]
+	from := c.state
+
+	switch c.state {
+	case CircuitHalfOpen:
+		if success {
+			c.state = CircuitClosed
+			c.windowStart, c.total, c.failures = time.Now(), 0, 0
+		} else {
+			c.state = CircuitOpen
+			c.openedAt = time.Now()
+		}
+	case CircuitClosed:
+		if time.Since(c.windowStart) >= b.opts.Window {
+			c.windowStart, c.total, c.failures = time.Now(), 0, 0
+		}
+		c.total++
+		if !success {
+			c.failures++
+		}
+		if c.total >= b.opts.MinRequests &&
+			float64(c.failures)/float64(c.total) >= b.opts.FailureRatio {
+			c.state = CircuitOpen
+			c.openedAt = time.Now()
+		}
+	}
+	to := c.state
+	b.mu.Unlock()
+
+	b.changed(name, from, to)
+}
+
+// abandon releases the trial taken by allow for a call whose result is not
+// recorded
+func (b *CircuitBreaker) abandon(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[name]
+	if c.state == CircuitHalfOpen && c.trials > 0 {
+		c.trials--
+	}
+}
+
+func (b *CircuitBreaker) changed(name string, from, to CircuitState) {
+	if from != to && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(name, from, to)
+	}
+}
+
+// breakerTransport is returned by CircuitBreaker.WrapTransport
+type breakerTransport struct {
+	b     *CircuitBreaker
+	name  string
+	trans Transport
+}
+
+func (t *breakerTransport) Send(in []byte) ([]byte, error) {
+	return t.SendContext(context.Background(), in)
+}
+
+func (t *breakerTransport) SendContext(ctx context.Context, in []byte) ([]byte, error) {
+	var out []byte
+	err := t.b.do(ctx, t.name, func() error {
+		var err error
+		out, err = sendContext(ctx, t.trans, in)
+		return err
+	})
+	return out, err
+}
+
+// breakerClient is returned by CircuitBreaker.WrapClient
+type breakerClient struct {
+	b      *CircuitBreaker
+	client Client
+}
+
+func (c *breakerClient) Call(method string, params ...interface{}) (interface{}, error) {
+	return c.CallContext(context.Background(), method, params...)
+}
+
+func (c *breakerClient) CallContext(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	var result interface{}
+	err := c.b.do(ctx, method, func() error {
+		var err error
+		result, err = CallContext(ctx, c.client, method, params...)
+		return err
+	})
+	return result, err
+}
+
+func (c *breakerClient) CallBatch(batch []JsonRpcRequest) []JsonRpcResponse {
+	return c.client.CallBatch(batch)
+}
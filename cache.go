@@ -0,0 +1,228 @@
+package barrister
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CacheBackend stores the encoded results cached by a CacheFilter.
+// Implementations must be safe for concurrent use.
+type CacheBackend interface {
+	// Get returns the value stored for key, if it has not expired
+	Get(key string) ([]byte, bool)
+
+	// Set stores value for key until ttl elapses
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// CacheFilter is a Filter that caches the results of IDL methods that return
+// the same result for the same params.  A cached result is returned from
+// PreInvoke without calling the handler or later Filters.
+//
+// Caching is enabled per method with the @cache annotation, whose value is
+// the time to live of the results, or with SetTTL:
+//
+//     // @cache 30s
+//     getProfile(userId string) Profile
+//
+// Results are keyed by method, params, and the value returned by Vary.
+// Only successful results are cached.  Cached results are returned as a
+// json.RawMessage, so Server.Call returns them in that form.
+//
+// Register CacheFilter after any AuthFilter and AuthzFilter, so cached
+// results are only returned to callers permitted to see them.
+//
+//     cache := barrister.NewCacheFilter(idl, barrister.NewLRUCache(10000))
+//     svr.AddFilter(cache)
+//
+type CacheFilter struct {
+	// Returns a string that is added to the cache key, so that callers that
+	// may see different results do not share entries.  Defaults to the
+	// Principal Id, or "" for unauthenticated requests.  Set before the
+	// Server starts.
+	Vary func(r *RequestResponse) string
+
+	idl     *Idl
+	backend CacheBackend
+	ttls    map[string]time.Duration
+}
+
+type cacheKeyKey struct{}
+
+// NewCacheFilter creates a CacheFilter that stores results in backend, for
+// the methods annotated with @cache in idl.  NewCacheFilter panics if a
+// @cache value is not a valid duration.
+func NewCacheFilter(idl *Idl, backend CacheBackend) *CacheFilter {
+	f := &CacheFilter{Vary: principalId, idl: idl, backend: backend, ttls: map[string]time.Duration{}}
+	for name, fn := range idl.methods {
+		val, ok := fn.Annotation("cache")
+		if !ok || fn.IsStream() || fn.IsEvent() {
+			continue
+		}
+		ttl, err := time.ParseDuration(val)
+		if err != nil {
+			panic(fmt.Sprintf("barrister: invalid @cache duration for %s: %s", name, val))
+		}
+		f.ttls[name] = ttl
+	}
+	return f
+}
+
+// SetTTL sets how long results of method are cached, replacing its @cache
+// annotation.  A ttl of zero disables caching of the method.  SetTTL panics if
+// method is not a function in the IDL, or is a stream or event function.
+// SetTTL must not be called while the Server is handling requests.
+func (f *CacheFilter) SetTTL(method string, ttl time.Duration) *CacheFilter {
+	fn, ok := f.idl.methods[method]
+	if !ok || fn.IsStream() || fn.IsEvent() {
+		panic(fmt.Sprintf("barrister: CacheFilter.SetTTL method can not be cached: %s", method))
+	}
+	if ttl > 0 {
+		f.ttls[method] = ttl
+	} else {
+		delete(f.ttls, method)
+	}
+	return f
+}
+
+// key returns the cache key of the request.  Params are encoded as JSON
+// and decoded into generic values before being encoded again, as for event
+// topics, so that a struct and a map with the same JSON have the same key.
+func (f *CacheFilter) key(r *RequestResponse) (string, error) {
+	b, err := json.Marshal(r.Params)
+	if err != nil {
+		return "", err
+	}
+	// numbers are kept as json.Number, so large integers are not rounded
+	var params interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err = dec.Decode(&params)
+	if err != nil {
+		return "", err
+	}
+	b, err = json.Marshal(struct {
+		Vary   string      `json:"v"`
+		Params interface{} `json:"p"`
+	}{f.Vary(r), params})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return r.Method + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+// PreInvoke returns the cached result of the request, if any
+func (f *CacheFilter) PreInvoke(r *RequestResponse) bool {
+	if _, ok := f.ttls[r.Method]; !ok {
+		return true
+	}
+	key, err := f.key(r)
+	if err != nil {
+		return true
+	}
+
+	val, ok := f.backend.Get(key)
+	if ok {
+		r.Result = json.RawMessage(val)
+		return false
+	}
+	r.Context = context.WithValue(r.Context, cacheKeyKey{}, key)
+	return true
+}
+
+// PostInvoke caches a successful result
+func (f *CacheFilter) PostInvoke(r *RequestResponse) bool {
+	key, ok := r.Context.Value(cacheKeyKey{}).(string)
+	if !ok || r.Err != nil {
+		return true
+	}
+	val, err := json.Marshal(r.Result)
+	if err == nil {
+		f.backend.Set(key, val, f.ttls[r.Method])
+	}
+	return true
+}
+
+// LRUCache is an in-memory CacheBackend that holds a fixed number of
+// entries, discarding the least recently used when full
+type LRUCache struct {
+	maxEntries int
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRUCache creates an LRUCache that holds up to maxEntries entries
+func NewLRUCache(maxEntries int) *LRUCache {
+	return &LRUCache{maxEntries: maxEntries, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+// Get implements CacheBackend
+func (c *LRUCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+// Set implements CacheBackend
+func (c *LRUCache) Set(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value, entry.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{key, value, expires})
+	for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*lruEntry).key)
+	}
+}
+
+// Len returns the number of entries, including expired entries not yet discarded
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Purge discards all entries
+func (c *LRUCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+}
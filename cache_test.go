@@ -0,0 +1,158 @@
+package barrister
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingCounter counts calls to Total
+type countingCounter struct {
+	CounterImpl
+	calls *int32
+}
+
+func (c countingCounter) Total(n int64) (int64, error) {
+	atomic.AddInt32(c.calls, 1)
+	return c.CounterImpl.Total(n)
+}
+
+func newCachingServer(backend CacheBackend) (Server, *CacheFilter, *int32) {
+	idl := MustParseIdlJson([]byte(strings.Replace(streamIdlJson,
+		`"comment": "@idempotent"`, `"comment": "@idempotent\n@cache 1m"`, 1)))
+	calls := new(int32)
+	svr := NewJSONServer(idl, true)
+	svr.AddHandler("Counter", countingCounter{calls: calls})
+	cache := NewCacheFilter(idl, backend)
+	svr.AddFilter(cache)
+	return svr, cache, calls
+}
+
+func TestCacheFilter(t *testing.T) {
+	svr, _, calls := newCachingServer(NewLRUCache(100))
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+	client := NewRemoteClient(&HttpTransport{Url: ts.URL}, true)
+
+	for i := 0; i < 3; i++ {
+		res, err := client.Call("Counter.total", 4)
+		if err != nil || res != 6.0 {
+			t.Fatalf("call %d: %v %v", i, res, err)
+		}
+	}
+	if *calls != 1 {
+		t.Errorf("handler called %d times, expected 1", *calls)
+	}
+
+	client.Call("Counter.total", 5)
+	if *calls != 2 {
+		t.Errorf("different params served from cache")
+	}
+
+	res, err := svr.Call(newHeaders(), "Counter.total", 4)
+	if err != nil || string(res.(json.RawMessage)) != "6" {
+		t.Errorf("unexpected cached result: %v %v", res, err)
+	}
+
+	// errors are not cached
+	for i := 0; i < 2; i++ {
+		_, err = client.Call("Counter.total", -1)
+		if err == nil {
+			t.Errorf("expected error")
+		}
+	}
+	if *calls != 4 {
+		t.Errorf("error result cached: %d calls", *calls)
+	}
+}
+
+func TestCacheFilterVary(t *testing.T) {
+	svr, cache, calls := newCachingServer(NewLRUCache(100))
+	cache.Vary = func(r *RequestResponse) string {
+		return GetFirst(r.Headers.Request, "X-Tenant")
+	}
+
+	for _, tenant := range []string{"a", "b", "a"} {
+		h := newHeaders()
+		h.Request["X-Tenant"] = []string{tenant}
+		svr.Call(h, "Counter.total", 4)
+	}
+	if *calls != 2 {
+		t.Errorf("handler called %d times, expected 2", *calls)
+	}
+
+	cache.SetTTL("Counter.total", 0)
+	svr.Call(newHeaders(), "Counter.total", 4)
+	svr.Call(newHeaders(), "Counter.total", 4)
+	if *calls != 4 {
+		t.Errorf("results cached after SetTTL(0): %d calls", *calls)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("SetTTL did not panic for a stream method")
+		}
+	}()
+	cache.SetTTL("Counter.count", time.Minute)
+}
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2)
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+	c.Get("a")
+	c.Set("c", []byte("3"), time.Minute)
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("least recently used entry not evicted")
+	}
+	if v, ok := c.Get("a"); !ok || string(v) != "1" {
+		t.Errorf("recently used entry evicted")
+	}
+
+	c.Set("d", []byte("4"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("d"); ok {
+		t.Errorf("expired entry returned")
+	}
+
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("Purge left %d entries", c.Len())
+	}
+}
+
+func TestCacheFilterKeyIsCanonical(t *testing.T) {
+	idl := parseTestIdl()
+	svr := NewJSONServer(idl, true)
+	svr.AddHandler("A", AImpl{})
+	svr.AddFilter(NewCacheFilter(idl, NewLRUCache(100)).SetTTL("A.putPerson", time.Minute))
+
+	email := "a@b.com"
+	res, err := svr.Call(newHeaders(), "A.putPerson", Person{PersonId: "p1", FirstName: "a", LastName: "b", Email: &email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.(json.RawMessage); ok {
+		t.Fatalf("first call served from cache")
+	}
+
+	// the same person as a map, whose keys are encoded in a different order
+	person := map[string]interface{}{"personId": "p1", "firstName": "a", "lastName": "b", "email": email}
+	res, err = svr.Call(newHeaders(), "A.putPerson", person)
+	if _, ok := res.(json.RawMessage); !ok || err != nil {
+		t.Errorf("map param not served from cache: %v %v", res, err)
+	}
+}
+
+func TestCacheFilterKeyKeepsLargeIntegers(t *testing.T) {
+	svr, _, calls := newCachingServer(NewLRUCache(100))
+	svr.Call(newHeaders(), "Counter.total", int64(1<<60))
+	svr.Call(newHeaders(), "Counter.total", int64(1<<60+1))
+	if *calls != 2 {
+		t.Errorf("handler called %d times, expected 2", *calls)
+	}
+}
@@ -0,0 +1,444 @@
+package barrister
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Persistent connections carry one serialized JSON-RPC message per line.
+// Any io.ReadWriter may be used: a TCP net.Conn, or a WebSocket connection
+// that implements net.Conn (e.g. golang.org/x/net/websocket.Conn).
+// Serialized JSON never contains a raw newline, so no other framing is needed.
+
+//////////////////////////////////////////////////
+// Server //
+////////////
+
+// ServeConn handles requests read from a persistent connection until reading
+// from conn fails.  Requests are handled concurrently, so responses may be
+// written in a different order than the requests were read.  Requests for
+// stream functions that set JsonRpcRequest.Stream are answered with a
+// sequence of StreamFrame messages.
+//
+// headers are passed to every request read from the connection.  Values that
+// handlers write to Headers.Response are discarded.  If conn is a *tls.Conn
+// and headers.TLS is nil, headers.TLS is set to the connection state.  If
+// conn is a net.Conn and headers.RemoteAddr is empty, it is set to the
+// address of the peer.
+//
+// Clients may subscribe to IDL event functions over the connection using the
+// barrister-subscribe method.  Events sent with Publish are written to the
+// connection as EventNotification messages.
+//
+// If the final frame of a stream can't be written and conn is an io.Closer,
+// conn is closed, which ends reading.  A request line longer than
+// PayloadLimits.MaxBodyBytes is answered with a -32600 error without an id,
+// then conn is closed and reading ends.  ServeConn waits for in-flight requests
+// to complete, then returns nil if conn returned io.EOF, or the read error
+// otherwise.
+func (s *Server) ServeConn(conn io.ReadWriter, headers Headers) error {
+	tc, ok := conn.(*tls.Conn)
+	if ok && headers.TLS == nil {
+		err := tc.Handshake()
+		if err != nil {
+			return err
+		}
+		state := tc.ConnectionState()
+		headers.TLS = &state
+	}
+	nc, ok := conn.(net.Conn)
+	if ok && headers.RemoteAddr == "" {
+		headers.RemoteAddr = nc.RemoteAddr().String()
+	}
+
+	r := bufio.NewReader(conn)
+
+	var wmu sync.Mutex
+	write := func(b []byte) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		_, err := conn.Write(append(b, '\n'))
+		return err
+	}
+
+	ec := s.events.openConn(write)
+
+	var wg sync.WaitGroup
+	for {
+		line, tooLong, err := readLine(r, s.payloadLimits.MaxBodyBytes)
+		line = bytes.TrimSpace(line)
+		if tooLong {
+			// the id of the request is unknown, so the error can't be
+			// matched to it by the client.  The connection is closed so
+			// that the client's pending requests fail.
+			rpcErr := &JsonRpcError{Code: -32600,
+				Message: fmt.Sprintf("barrister: request exceeds %d bytes", s.payloadLimits.MaxBodyBytes)}
+			write(s.errorBytes(rpcErr))
+			if c, ok := conn.(io.Closer); ok {
+				c.Close()
+			}
+			err = rpcErr
+		} else if len(line) > 0 {
+			reqHeaders := Headers{
+				Request:    headers.Request,
+				Cookies:    headers.Cookies,
+				Response:   make(map[string][]string),
+				Body:       line,
+				TLS:        headers.TLS,
+				RemoteAddr: headers.RemoteAddr,
+			}
+
+			wg.Add(1)
+			go func(req []byte) {
+				defer wg.Done()
+				err := s.invokeConn(reqHeaders, req, write, ec)
+				if c, ok := conn.(io.Closer); ok && err != nil {
+					c.Close()
+				}
+			}(line)
+		}
+
+		if err != nil {
+			wg.Wait()
+			s.events.closeConn(ec)
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// invokeConn handles a single line read by ServeConn.  It returns the error
+// from InvokeStream, if any.
+func (s *Server) invokeConn(headers Headers, req []byte, write func(b []byte) error, ec *eventConn) error {
+	// TODO: log write errors?
+	if s.ser.IsBatch(req) {
+		write(s.InvokeBytes(headers, req))
+		return nil
+	}
+
+	rpcReq := JsonRpcRequest{}
+	err := s.ser.Unmarshal(req, &rpcReq)
+	if err != nil {
+		write(jsonParseErr("", false, err))
+	} else if s.isStreamReq(&rpcReq) {
+		return s.InvokeStream(headers, &rpcReq, write)
+	} else if rpcReq.Method == "barrister-subscribe" || rpcReq.Method == "barrister-unsubscribe" {
+		b, err := s.ser.Marshal(s.invokeSubscription(reservedRequestHeaders(headers, &rpcReq), ec, &rpcReq))
+		if err != nil {
+			panic(err)
+		}
+		write(b)
+	} else {
+		write(s.invokeOneBytes(headers, &rpcReq))
+	}
+	return nil
+}
+
+//////////////////////////////////////////////////
+// Client //
+////////////
+
+// NewConnTransport creates a ConnTransport and starts reading responses from conn
+func NewConnTransport(conn io.ReadWriteCloser) *ConnTransport {
+	t := &ConnTransport{conn: conn, pending: map[string]*connCall{},
+		handlers: map[string]*connCall{}}
+	go t.readLoop()
+	return t
+}
+
+// ConnTransport sends requests over a persistent connection to a server
+// using Server.ServeConn.  ConnTransport is safe for concurrent use, and
+// responses are matched to requests by id, so many requests may be in flight
+// at once.  Batch requests are matched by the id of their first element.
+//
+// Responses are read by a single goroutine.  If the frames of a stream are
+// not consumed, reading of other responses is delayed until the stream is
+// closed.  Likewise, each notification handler runs in its own goroutine,
+// and a handler that falls behind delays reading, which in turn slows the
+// server's delivery of events to this connection.
+type ConnTransport struct {
+	conn io.ReadWriteCloser
+
+	// serializes writes to conn
+	wmu sync.Mutex
+
+	// guards pending, handlers and err
+	mu       sync.Mutex
+	pending  map[string]*connCall
+	handlers map[string]*connCall
+
+	// set when readLoop exits
+	err error
+}
+
+// connCall is a request awaiting its response (or stream frames), or
+// a subscription awaiting notifications
+type connCall struct {
+	ch   chan []byte
+	done chan struct{}
+}
+
+// Send writes the request to the connection and waits for the response
+func (t *ConnTransport) Send(in []byte) ([]byte, error) {
+	return t.SendContext(context.Background(), in)
+}
+
+// SendContext writes the request to the connection and waits for the
+// response until the context is done
+func (t *ConnTransport) SendContext(ctx context.Context, in []byte) ([]byte, error) {
+	id := messageId(in)
+	call, err := t.register(id, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer t.unregister(id, call)
+
+	err = t.write(in)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case b, ok := <-call.ch:
+		if !ok {
+			return nil, t.readErr()
+		}
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// SendStream writes the request to the connection and returns a FrameReader
+// over the frames that the server sends in reply
+func (t *ConnTransport) SendStream(in []byte) (FrameReader, error) {
+	id := messageId(in)
+	call, err := t.register(id, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.write(in)
+	if err != nil {
+		t.unregister(id, call)
+		return nil, err
+	}
+
+	return &connFrameReader{t, id, call}, nil
+}
+
+// Close closes the underlying connection.  Requests waiting for a response
+// fail with an error.
+func (t *ConnTransport) Close() error {
+	return t.conn.Close()
+}
+
+// HandleNotifications calls handler with the payload of each notification
+// for the given subscription.  Notifications are queued for the handler,
+// so it may call other methods using this Transport.
+func (t *ConnTransport) HandleNotifications(subscription string, handler func(result []byte)) {
+	call := &connCall{make(chan []byte, 32), make(chan struct{})}
+
+	t.mu.Lock()
+	old, ok := t.handlers[subscription]
+	if ok {
+		close(old.done)
+	}
+	t.handlers[subscription] = call
+	t.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case b := <-call.ch:
+				handler(b)
+			case <-call.done:
+				return
+			}
+		}
+	}()
+}
+
+// RemoveNotifications stops calling the handler for the given subscription
+func (t *ConnTransport) RemoveNotifications(subscription string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	call, ok := t.handlers[subscription]
+	if ok {
+		delete(t.handlers, subscription)
+		close(call.done)
+	}
+}
+
+func (t *ConnTransport) write(in []byte) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+
+	_, err := t.conn.Write(append(in, '\n'))
+	if err != nil {
+		return fmt.Errorf("barrister: ConnTransport write failed: %s", err)
+	}
+	return nil
+}
+
+func (t *ConnTransport) register(id string, buffer int) (*connCall, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	_, ok := t.pending[id]
+	if ok {
+		return nil, fmt.Errorf("barrister: ConnTransport request id already in flight: %s", id)
+	}
+
+	call := &connCall{make(chan []byte, buffer), make(chan struct{})}
+	t.pending[id] = call
+	return call, nil
+}
+
+func (t *ConnTransport) unregister(id string, call *connCall) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pending[id] == call {
+		delete(t.pending, id)
+		close(call.done)
+	}
+}
+
+func (t *ConnTransport) readErr() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// readLoop delivers each line read from the connection to the pending
+// request with the same id.  Lines that match no request are dropped.
+func (t *ConnTransport) readLoop() {
+	r := bufio.NewReader(t.conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			t.dispatch(line)
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			t.mu.Lock()
+			t.err = fmt.Errorf("barrister: ConnTransport read failed: %s", err)
+			for id, call := range t.pending {
+				delete(t.pending, id)
+				close(call.ch)
+			}
+			t.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (t *ConnTransport) dispatch(line []byte) {
+	id := messageId(line)
+	if id == "" {
+		t.dispatchNotification(line)
+		return
+	}
+
+	t.mu.Lock()
+	call, ok := t.pending[id]
+	t.mu.Unlock()
+
+	if ok {
+		select {
+		case call.ch <- line:
+		case <-call.done:
+		}
+	}
+}
+
+func (t *ConnTransport) dispatchNotification(line []byte) {
+	var n struct {
+		Params struct {
+			Subscription string          `json:"subscription"`
+			Result       json.RawMessage `json:"result"`
+		} `json:"params"`
+	}
+	err := json.Unmarshal(line, &n)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	call, ok := t.handlers[n.Params.Subscription]
+	t.mu.Unlock()
+
+	if ok {
+		select {
+		case call.ch <- []byte(n.Params.Result):
+		case <-call.done:
+		}
+	}
+}
+
+// connFrameReader reads the frames of a single stream from a ConnTransport
+type connFrameReader struct {
+	t    *ConnTransport
+	id   string
+	call *connCall
+}
+
+func (r *connFrameReader) ReadFrame() ([]byte, error) {
+	select {
+	case b, ok := <-r.call.ch:
+		if !ok {
+			return nil, io.EOF
+		}
+		return b, nil
+	case <-r.call.done:
+		return nil, io.EOF
+	}
+}
+
+func (r *connFrameReader) Close() error {
+	r.t.unregister(r.id, r.call)
+	return nil
+}
+
+// messageId returns the id of a serialized request or response, or the id
+// of the first element of a batch.  An empty string is returned if the
+// message has no id.
+func messageId(b []byte) string {
+	type idOnly struct {
+		Id string `json:"id"`
+	}
+
+	ser := JsonSerializer{}
+	if ser.IsBatch(b) {
+		var batch []idOnly
+		json.Unmarshal(b, &batch)
+		if len(batch) > 0 {
+			return batch[0].Id
+		}
+		return ""
+	}
+
+	var msg idOnly
+	json.Unmarshal(b, &msg)
+	return msg.Id
+}
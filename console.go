@@ -0,0 +1,276 @@
+package barrister
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+)
+
+// Console is an http.Handler that serves an interactive web console for a
+// Server.  The console fetches the IDL from the Server with barrister-idl,
+// lists its interfaces and methods, renders a form for the params of the
+// selected method (with dropdowns for enums and nested forms for structs),
+// and shows the JSON response of each call.
+//
+//     http.Handle("/rpc", &svr)
+//     http.Handle("/console/", barrister.NewConsole("/rpc"))
+//
+// Calls made from the console pass through the Server's Filters like any
+// other.  Extra request headers, e.g. Authorization, can be entered in the
+// console.  Set Disabled, or don't mount the Console, in production.
+type Console struct {
+	// URL of the Server endpoint, relative to the console page or absolute
+	Endpoint string
+
+	// If true, the console responds 404 Not Found
+	Disabled bool
+
+	// Optional func that decides if a request may use the console, e.g. to
+	// restrict it to internal addresses.  Denied requests get 403 Forbidden.
+	Allow func(req *http.Request) bool
+}
+
+// NewConsole creates a Console for the Server at endpoint
+func NewConsole(endpoint string) *Console {
+	return &Console{Endpoint: endpoint}
+}
+
+// ServeHTTP serves the console page
+func (c *Console) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if c.Disabled {
+		http.NotFound(w, req)
+		return
+	}
+	if c.Allow != nil && !c.Allow(req) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var b bytes.Buffer
+	err := consoleTemplate.Execute(&b, c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	if req.Method == "GET" {
+		w.Write(b.Bytes())
+	}
+}
+
+var consoleTemplate = template.Must(template.New("console").Parse(consoleHtml))
+
+const consoleHtml = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Barrister Console</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; height: 100vh; }
+#nav { width: 260px; overflow-y: auto; border-right: 1px solid #ccc; padding: 8px; }
+#main { flex: 1; overflow-y: auto; padding: 8px 16px; }
+#nav h3 { margin: 12px 0 4px; font-size: 14px; }
+#nav a { display: block; padding: 2px 8px; color: #036; text-decoration: none; font-size: 13px; }
+#nav a.selected { background: #def; }
+fieldset { margin: 4px 0; border: 1px solid #ddd; }
+label { display: block; margin: 4px 0; font-size: 13px; }
+label span.type { color: #888; }
+pre { background: #f4f4f4; padding: 8px; overflow-x: auto; }
+.comment { color: #555; white-space: pre-wrap; }
+.error { color: #a00; }
+textarea { width: 100%; font-family: monospace; }
+</style>
+</head>
+<body>
+<div id="nav"></div>
+<div id="main">
+  <p>Endpoint: <code id="endpoint"></code></p>
+  <label>Request headers (JSON object)
+    <textarea id="headers" rows="2">{}</textarea>
+  </label>
+  <div id="method"><p>Select a method.</p></div>
+  <div id="response"></div>
+</div>
+<script>
+(function() {
+  var endpoint = {{.Endpoint}};
+  var structs = {}, enums = {}, ifaces = [];
+
+  document.getElementById("endpoint").textContent = endpoint;
+
+  function el(tag, attrs, children) {
+    var e = document.createElement(tag);
+    for (var k in attrs || {}) {
+      if (k === "text") { e.textContent = attrs[k]; } else { e.setAttribute(k, attrs[k]); }
+    }
+    (children || []).forEach(function(c) { e.appendChild(c); });
+    return e;
+  }
+
+  function typeName(f) {
+    return (f.is_array ? "[]" : "") + f.type + (f.optional ? " [optional]" : "");
+  }
+
+  function structFields(name) {
+    var s = structs[name], fields = [];
+    while (s) {
+      fields = s.fields.concat(fields);
+      s = s.extends ? structs[s.extends] : null;
+    }
+    return fields;
+  }
+
+  // input returns an element for a value of field f, with a value_() func
+  function input(f) {
+    var wrap;
+    if (f.is_array) {
+      wrap = el("textarea", {rows: 3, placeholder: "JSON array"});
+      wrap.value = f.optional ? "" : "[]";
+      wrap.value_ = function() { return wrap.value.trim() === "" ? null : JSON.parse(wrap.value); };
+    } else if (f.type === "bool") {
+      wrap = el("input", {type: "checkbox"});
+      wrap.value_ = function() { return wrap.checked; };
+    } else if (enums[f.type]) {
+      wrap = el("select");
+      if (f.optional) { wrap.appendChild(el("option", {value: "", text: "(null)"})); }
+      enums[f.type].forEach(function(v) { wrap.appendChild(el("option", {value: v.value, text: v.value})); });
+      wrap.value_ = function() { return wrap.value === "" ? null : wrap.value; };
+    } else if (structs[f.type]) {
+      var inputs = [];
+      wrap = el("fieldset", {}, [el("legend", {text: f.type})]);
+      var nullBox = null;
+      if (f.optional) {
+        nullBox = el("input", {type: "checkbox"});
+        wrap.appendChild(el("label", {}, [nullBox, document.createTextNode(" null")]));
+      }
+      structFields(f.type).forEach(function(sf) {
+        var i = input(sf);
+        inputs.push([sf.name, i]);
+        wrap.appendChild(label(sf, i));
+      });
+      wrap.value_ = function() {
+        if (nullBox && nullBox.checked) { return null; }
+        var obj = {};
+        inputs.forEach(function(p) {
+          var v = p[1].value_();
+          if (v !== null) { obj[p[0]] = v; }
+        });
+        return obj;
+      };
+    } else {
+      wrap = el("input", {type: "text"});
+      wrap.value_ = function() {
+        if (wrap.value === "" && f.optional) { return null; }
+        if (f.type === "int") { return parseInt(wrap.value, 10); }
+        if (f.type === "float") { return parseFloat(wrap.value); }
+        return wrap.value;
+      };
+    }
+    return wrap;
+  }
+
+  function label(f, i) {
+    return el("label", {}, [document.createTextNode(f.name + " "),
+      el("span", {"class": "type", text: typeName(f)}), el("br"), i]);
+  }
+
+  function call(method, params, done) {
+    var headers;
+    try {
+      headers = JSON.parse(document.getElementById("headers").value || "{}");
+    } catch (e) {
+      done(null, "invalid request headers: " + e);
+      return;
+    }
+    var xhr = new XMLHttpRequest();
+    xhr.open("POST", endpoint);
+    xhr.setRequestHeader("Content-Type", "application/json");
+    for (var h in headers) { xhr.setRequestHeader(h, headers[h]); }
+    xhr.onload = function() {
+      try {
+        done(JSON.parse(xhr.responseText), null);
+      } catch (e) {
+        done(null, "HTTP " + xhr.status + ": " + xhr.responseText);
+      }
+    };
+    xhr.onerror = function() { done(null, "request failed"); };
+    xhr.send(JSON.stringify({jsonrpc: "2.0", id: String(Date.now()), method: method, params: params}));
+  }
+
+  function showMethod(iface, fn, link) {
+    var links = document.querySelectorAll("#nav a");
+    for (var i = 0; i < links.length; i++) { links[i].className = ""; }
+    link.className = "selected";
+
+    var method = iface.name + "." + fn.name;
+    var div = document.getElementById("method");
+    div.innerHTML = "";
+    document.getElementById("response").innerHTML = "";
+    div.appendChild(el("h2", {text: method}));
+    if (fn.comment) { div.appendChild(el("p", {"class": "comment", text: fn.comment})); }
+    div.appendChild(el("p", {text: "returns " + typeName(fn.returns)}));
+
+    var inputs = fn.params.map(function(p) {
+      var i = input(p);
+      div.appendChild(label(p, i));
+      return i;
+    });
+
+    var button = el("button", {text: "Call"});
+    button.onclick = function() {
+      var out = document.getElementById("response");
+      var params;
+      try {
+        params = inputs.map(function(i) { return i.value_(); });
+      } catch (e) {
+        out.innerHTML = "";
+        out.appendChild(el("p", {"class": "error", text: "invalid params: " + e}));
+        return;
+      }
+      var start = Date.now();
+      call(method, params, function(resp, err) {
+        out.innerHTML = "";
+        if (err) {
+          out.appendChild(el("p", {"class": "error", text: err}));
+          return;
+        }
+        out.appendChild(el("p", {text: (Date.now() - start) + "ms"}));
+        out.appendChild(el("pre", {"class": resp.error ? "error" : "", text: JSON.stringify(resp, null, 2)}));
+      });
+    };
+    div.appendChild(button);
+  }
+
+  call("barrister-idl", [], function(resp, err) {
+    var nav = document.getElementById("nav");
+    if (err || resp.error) {
+      nav.appendChild(el("p", {"class": "error", text: err || resp.error.message}));
+      return;
+    }
+    resp.result.forEach(function(e) {
+      if (e.type === "struct") { structs[e.name] = e; }
+      if (e.type === "enum") { enums[e.name] = e.values; }
+      if (e.type === "interface") { ifaces.push(e); }
+    });
+    ifaces.forEach(function(iface) {
+      nav.appendChild(el("h3", {text: iface.name}));
+      iface.functions.forEach(function(fn) {
+        if (/(^|\n)\s*@event\b/.test(fn.comment || "")) { return; }
+        var link = el("a", {href: "#", text: fn.name});
+        link.onclick = function(ev) { ev.preventDefault(); showMethod(iface, fn, link); };
+        nav.appendChild(link);
+      });
+    });
+  });
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,48 @@
+package barrister
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConsole(t *testing.T) {
+	console := NewConsole("/rpc?x=</script>")
+
+	rec := httptest.NewRecorder()
+	console.ServeHTTP(rec, httptest.NewRequest("GET", "/console/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d != 200", rec.Code)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("unexpected Content-Type: %s", rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "barrister-idl") {
+		t.Errorf("console page does not fetch the IDL")
+	}
+	if !strings.Contains(body, `var endpoint = "`) || strings.Contains(body, "x=</script>") {
+		t.Errorf("endpoint not escaped")
+	}
+
+	rec = httptest.NewRecorder()
+	console.ServeHTTP(rec, httptest.NewRequest("POST", "/console/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status %d != 405", rec.Code)
+	}
+
+	console.Allow = func(req *http.Request) bool { return req.Header.Get("X-Internal") != "" }
+	rec = httptest.NewRecorder()
+	console.ServeHTTP(rec, httptest.NewRequest("GET", "/console/", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status %d != 403", rec.Code)
+	}
+
+	console.Disabled = true
+	rec = httptest.NewRecorder()
+	console.ServeHTTP(rec, httptest.NewRequest("GET", "/console/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status %d != 404", rec.Code)
+	}
+}
@@ -36,10 +36,15 @@ type convert struct {
 	actual    interface{}
 	converted reflect.Value
 	path      string
+
+	// optional limits on arrays and nesting, and the number of arrays and
+	// structs that enclose the value
+	limits *PayloadLimits
+	depth  int
 }
 
 func newConvert(idl *Idl, field *Field, desired reflect.Type, actual interface{}, path string) *convert {
-	return &convert{idl, field, desired, false, actual, zeroVal, path}
+	return &convert{idl, field, desired, false, actual, zeroVal, path, nil, 0}
 }
 
 func (c *convert) run() (reflect.Value, error) {
@@ -199,6 +204,10 @@ func (c *convert) run() (reflect.Value, error) {
 
 func (c *convert) convertSlice(actVal reflect.Value) (reflect.Value, error) {
 	length := actVal.Len()
+	err := c.checkLimits(length)
+	if err != nil {
+		return zeroVal, err
+	}
 	slice := reflect.MakeSlice(c.desired, length, length)
 
 	elemField := &Field{Name: c.field.Name, Type: c.field.Type,
@@ -207,13 +216,19 @@ func (c *convert) convertSlice(actVal reflect.Value) (reflect.Value, error) {
 	sliceType := c.desired.Elem()
 
 	elemConv := newConvert(c.idl, elemField, sliceType, nil, "")
+	elemConv.limits, elemConv.depth = c.limits, c.depth+1
 
 	for x := 0; x < length; x++ {
 
 		el := actVal.Index(x)
 		elemConv.actual = el.Interface()
 
-		elemConv.path = c.path + "[" + string(x) + "]"
+		// run replaces desired with the pointed to type for slices of
+		// pointers, so it must be reset for each element
+		elemConv.desired = sliceType
+		elemConv.desirePtr = false
+
+		elemConv.path = fmt.Sprintf("%s[%d]", c.path, x)
 
 		conv, err := elemConv.run()
 		if err != nil {
@@ -236,6 +251,11 @@ func (c *convert) convertStruct(m map[string]interface{}) (reflect.Value, error)
 		return zeroVal, &typeError{path: c.path, msg: msg}
 	}
 
+	err := c.checkLimits(0)
+	if err != nil {
+		return zeroVal, err
+	}
+
 	val := reflect.New(c.desired)
 
 	for _, sField := range idlStruct.allFields {
@@ -264,6 +284,7 @@ func (c *convert) convertStruct(m map[string]interface{}) (reflect.Value, error)
 
 			fieldConv := newConvert(c.idl, &sField, structField.Type, mval,
 				c.path+"."+fname)
+			fieldConv.limits, fieldConv.depth = c.limits, c.depth+1
 			conv, err := fieldConv.run()
 			if err != nil {
 				return zeroVal, err
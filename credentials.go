@@ -0,0 +1,87 @@
+package barrister
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// BearerCredentials is an Interceptor that sends an
+// "Authorization: Bearer <token>" header with each call made over HTTP.
+//
+//     client.AddInterceptor(barrister.NewBearerCredentials(token))
+//
+// To refresh tokens, set Token to a func that returns the current token.
+type BearerCredentials struct {
+	// Returns the token for a call.  An error fails the call with code -32603
+	// before the request is sent.
+	Token func(ctx context.Context) (string, error)
+}
+
+// NewBearerCredentials creates a BearerCredentials that always sends token
+func NewBearerCredentials(token string) *BearerCredentials {
+	return &BearerCredentials{Token: func(ctx context.Context) (string, error) {
+		return token, nil
+	}}
+}
+
+// PreCall adds the Authorization header to the call's context
+func (b *BearerCredentials) PreCall(c *ClientCall) bool {
+	token, err := b.Token(c.Context)
+	if err != nil {
+		c.Err = &JsonRpcError{Code: -32603, Message: fmt.Sprintf("barrister: unable to get bearer token: %s", err)}
+		return false
+	}
+	c.Context = setRequestHeader(c.Context, "Authorization", "Bearer "+token)
+	return true
+}
+
+// PostCall does nothing
+func (b *BearerCredentials) PostCall(c *ClientCall) bool {
+	return true
+}
+
+// HMACSigner is an HttpHook that signs each request body with a shared
+// secret, for servers that use HMACAuthenticator.
+//
+//     trans := &barrister.HttpTransport{Url: url,
+//         Hook: &barrister.HMACSigner{KeyId: "billing", Secret: secret}}
+//
+type HMACSigner struct {
+	KeyId  string
+	Secret []byte
+}
+
+// Before adds the HMACScheme Authorization header to req
+func (s *HMACSigner) Before(req *http.Request, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Authorization", fmt.Sprintf("%s keyId=%s,ts=%s,sig=%s",
+		HMACScheme, s.KeyId, ts, hmacSignature(s.Secret, ts, body)))
+}
+
+// After does nothing
+func (s *HMACSigner) After(req *http.Request, resp *http.Response, body []byte) {
+}
+
+// NewMutualTLSRoundTripper returns an http.RoundTripper, for use as
+// HttpTransport.RoundTripper, that presents cert to servers that use
+// CertAuthenticator.  If roots is not nil, server certificates are verified
+// against it instead of the system roots.  The connection pool settings of
+// DefaultRoundTripper are used, if it is an *http.Transport.
+func NewMutualTLSRoundTripper(cert tls.Certificate, roots *x509.CertPool) http.RoundTripper {
+	var rt *http.Transport
+	if t, ok := DefaultRoundTripper.(*http.Transport); ok {
+		rt = t.Clone()
+	} else {
+		rt = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	}
+	rt.TLSClientConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+	}
+	return rt
+}
@@ -0,0 +1,425 @@
+package barrister
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// GenerateMarkdown renders documentation of the IDL as a single Markdown
+// document with the given title.  It lists the interfaces with their method
+// signatures, and the structs and enums, grouped by namespace.  Comments from
+// the IDL are included, types are linked to their definitions, and structs
+// show the structs they extend and are extended by.
+func (idl *Idl) GenerateMarkdown(title string) []byte {
+	g := newDocGen(idl)
+	b := &bytes.Buffer{}
+
+	fmt.Fprintf(b, "# %s\n\n", title)
+	g.markdownMeta(b)
+
+	if len(g.namespaces) > 1 {
+		b.WriteString("## Namespaces\n\n")
+		for _, ns := range g.namespaces {
+			fmt.Fprintf(b, "* [%s](#%s)\n", g.nsTitle(ns), nsAnchor(ns))
+		}
+		b.WriteString("\n")
+	}
+
+	for _, ns := range g.namespaces {
+		if len(g.namespaces) > 1 {
+			fmt.Fprintf(b, "<a name=\"%s\"></a>\n## %s\n\n", nsAnchor(ns), g.nsTitle(ns))
+		}
+		for _, el := range g.elems[ns] {
+			g.markdownElem(b, el)
+		}
+	}
+	return b.Bytes()
+}
+
+// GenerateHTML renders documentation of the IDL as a browsable HTML site
+// with the given title.  The site is returned as a map of file name to
+// content: "index.html" has the Meta, a list of namespaces, and the elements
+// without a namespace, and each namespace has a page named after it, e.g.
+// "common.html".  The content is the same as GenerateMarkdown.
+func (idl *Idl) GenerateHTML(title string) map[string][]byte {
+	g := newDocGen(idl)
+	site := map[string][]byte{}
+
+	index := &bytes.Buffer{}
+	g.htmlHeader(index, title)
+	g.htmlMeta(index)
+	if len(g.namespaces) > 1 || (len(g.namespaces) == 1 && g.namespaces[0] != "") {
+		index.WriteString("<h2>Namespaces</h2>\n<ul>\n")
+		for _, ns := range g.namespaces {
+			if ns != "" {
+				fmt.Fprintf(index, "<li><a href=\"%s\">%s</a></li>\n", nsPage(ns), esc(ns))
+			}
+		}
+		index.WriteString("</ul>\n")
+	}
+	g.htmlElems(index, "")
+	index.WriteString("</body>\n</html>\n")
+	site["index.html"] = index.Bytes()
+
+	for _, ns := range g.namespaces {
+		if ns == "" {
+			continue
+		}
+		b := &bytes.Buffer{}
+		g.htmlHeader(b, title+" - "+ns)
+		fmt.Fprintf(b, "<p><a href=\"index.html\">%s</a> &raquo; %s</p>\n", esc(title), esc(ns))
+		g.htmlElems(b, ns)
+		b.WriteString("</body>\n</html>\n")
+		site[nsPage(ns)] = b.Bytes()
+	}
+	return site
+}
+
+// docGen holds the IDL elements arranged for documentation
+type docGen struct {
+	idl *Idl
+
+	// namespaces in the order they first appear, "" for elements without one
+	namespaces []string
+	elems      map[string][]IdlJsonElem
+
+	// names of the structs that directly extend each struct
+	subtypes map[string][]string
+}
+
+func newDocGen(idl *Idl) *docGen {
+	g := &docGen{idl: idl, elems: map[string][]IdlJsonElem{}, subtypes: map[string][]string{}}
+	for _, el := range idl.elems {
+		if el.Type == "meta" {
+			continue
+		}
+		ns := ""
+		if el.Type != "comment" {
+			ns, _ = splitNs(el.Name)
+		}
+		if _, ok := g.elems[ns]; !ok {
+			g.namespaces = append(g.namespaces, ns)
+		}
+		g.elems[ns] = append(g.elems[ns], el)
+		if el.Type == "struct" && el.Extends != "" {
+			g.subtypes[el.Extends] = append(g.subtypes[el.Extends], el.Name)
+		}
+	}
+	return g
+}
+
+func (g *docGen) nsTitle(ns string) string {
+	if ns == "" {
+		return "(default)"
+	}
+	return ns
+}
+
+func nsAnchor(ns string) string {
+	return "ns-" + ns
+}
+
+func nsPage(ns string) string {
+	return ns + ".html"
+}
+
+func esc(s string) string {
+	return html.EscapeString(s)
+}
+
+// isUserType returns true if name is a struct or enum in the IDL
+func (g *docGen) isUserType(name string) bool {
+	_, isStruct := g.idl.structs[name]
+	_, isEnum := g.idl.enums[name]
+	return isStruct || isEnum
+}
+
+// generatedAt returns the Meta date as text, or "" if unknown
+func (g *docGen) generatedAt() string {
+	if g.idl.Meta.DateGenerated == 0 {
+		return ""
+	}
+	return time.Unix(0, g.idl.Meta.DateGenerated).UTC().Format(time.RFC3339)
+}
+
+// typeRef renders the type of f, passing user defined type names to link
+func typeRef(f Field, link func(name string) string, text func(s string) string) string {
+	s := ""
+	if f.IsArray {
+		s = text("[]")
+	}
+	s += link(f.Type)
+	if f.Optional {
+		s += text(" [optional]")
+	}
+	return s
+}
+
+// signature renders fn as it is declared in the IDL
+func signature(fn Function, link func(name string) string, text func(s string) string) string {
+	params := []string{}
+	for _, p := range fn.Params {
+		params = append(params, text(p.Name+" ")+typeRef(p, link, text))
+	}
+	return text(fn.Name+"(") + strings.Join(params, text(", ")) + text(") ") + typeRef(fn.Returns, link, text)
+}
+
+// ancestors returns the chain of structs that name extends, nearest first
+func (g *docGen) ancestors(name string) []string {
+	chain := []string{}
+	s := g.idl.structs[name]
+	for s != nil && s.Extends != "" && len(chain) < len(g.idl.structs) {
+		chain = append(chain, s.Extends)
+		s = g.idl.structs[s.Extends]
+	}
+	return chain
+}
+
+////////////////////////////////////
+// Markdown
+
+func (g *docGen) mdLink(name string) string {
+	if g.isUserType(name) {
+		return fmt.Sprintf("[%s](#%s)", name, name)
+	}
+	return name
+}
+
+func mdText(s string) string {
+	return s
+}
+
+func (g *docGen) markdownMeta(b *bytes.Buffer) {
+	meta := g.idl.Meta
+	if meta.Checksum != "" {
+		fmt.Fprintf(b, "* Checksum: `%s`\n", meta.Checksum)
+	}
+	if at := g.generatedAt(); at != "" {
+		fmt.Fprintf(b, "* Generated: %s\n", at)
+	}
+	if meta.BarristerVersion != "" {
+		fmt.Fprintf(b, "* Barrister version: %s\n", meta.BarristerVersion)
+	}
+	b.WriteString("\n")
+}
+
+func (g *docGen) markdownElem(b *bytes.Buffer, el IdlJsonElem) {
+	switch el.Type {
+	case "comment":
+		fmt.Fprintf(b, "%s\n\n", el.Value)
+	case "interface":
+		fmt.Fprintf(b, "<a name=\"%s\"></a>\n### interface %s\n\n", el.Name, el.Name)
+		markdownComment(b, el.Comment)
+		for _, fn := range el.Functions {
+			fmt.Fprintf(b, "<a name=\"%s.%s\"></a>\n#### %s\n\n", el.Name, fn.Name, fn.Name)
+			fmt.Fprintf(b, "    %s\n\n", signature(fn, mdText, mdText))
+			markdownComment(b, fn.Comment)
+			if len(fn.Params) > 0 {
+				b.WriteString("| Param | Type | Description |\n|---|---|---|\n")
+				for _, p := range fn.Params {
+					g.markdownFieldRow(b, p, "")
+				}
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(b, "Returns: %s\n\n", typeRef(fn.Returns, g.mdLink, mdText))
+		}
+	case "struct":
+		fmt.Fprintf(b, "<a name=\"%s\"></a>\n### struct %s\n\n", el.Name, el.Name)
+		markdownComment(b, el.Comment)
+		if chain := g.ancestors(el.Name); len(chain) > 0 {
+			links := []string{el.Name}
+			for _, name := range chain {
+				links = append(links, g.mdLink(name))
+			}
+			fmt.Fprintf(b, "Extends: %s\n\n", strings.Join(links, " &rarr; "))
+		}
+		if subs := g.subtypes[el.Name]; len(subs) > 0 {
+			links := []string{}
+			for _, name := range subs {
+				links = append(links, g.mdLink(name))
+			}
+			fmt.Fprintf(b, "Extended by: %s\n\n", strings.Join(links, ", "))
+		}
+		b.WriteString("| Field | Type | Description |\n|---|---|---|\n")
+		for _, f := range el.Fields {
+			g.markdownFieldRow(b, f, "")
+		}
+		for _, parent := range g.ancestors(el.Name) {
+			for _, f := range g.idl.structs[parent].Fields {
+				g.markdownFieldRow(b, f, parent)
+			}
+		}
+		b.WriteString("\n")
+	case "enum":
+		fmt.Fprintf(b, "<a name=\"%s\"></a>\n### enum %s\n\n", el.Name, el.Name)
+		markdownComment(b, el.Comment)
+		b.WriteString("| Value | Description |\n|---|---|\n")
+		for _, v := range el.Values {
+			fmt.Fprintf(b, "| `%s` | %s |\n", v.Value, mdCell(v.Comment))
+		}
+		b.WriteString("\n")
+	}
+}
+
+// markdownFieldRow writes a table row for f, which is inherited from the
+// struct named from, if not ""
+func (g *docGen) markdownFieldRow(b *bytes.Buffer, f Field, from string) {
+	desc := mdCell(f.Comment)
+	if from != "" {
+		desc = strings.TrimSpace("(from " + g.mdLink(from) + ") " + desc)
+	}
+	fmt.Fprintf(b, "| %s | %s | %s |\n", f.Name, typeRef(f, g.mdLink, mdText), desc)
+}
+
+func markdownComment(b *bytes.Buffer, comment string) {
+	if comment != "" {
+		fmt.Fprintf(b, "%s\n\n", comment)
+	}
+}
+
+// mdCell formats s for a Markdown table cell
+func mdCell(s string) string {
+	return strings.Replace(strings.Replace(s, "|", "\\|", -1), "\n", " ", -1)
+}
+
+////////////////////////////////////
+// HTML
+
+const docCss = `body { font-family: sans-serif; max-width: 960px; margin: 0 auto; padding: 0 16px; }
+code, pre { background: #f4f4f4; }
+pre { padding: 8px; }
+table { border-collapse: collapse; margin: 8px 0; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+.comment { white-space: pre-wrap; }
+h2 { border-bottom: 1px solid #ccc; margin-top: 32px; }`
+
+// htmlLink links name to its definition, which may be on another page
+func (g *docGen) htmlLink(name string) string {
+	if !g.isUserType(name) {
+		return esc(name)
+	}
+	ns, _ := splitNs(name)
+	page := "index.html"
+	if ns != "" {
+		page = nsPage(ns)
+	}
+	return fmt.Sprintf("<a href=\"%s#%s\">%s</a>", page, esc(name), esc(name))
+}
+
+func (g *docGen) htmlHeader(b *bytes.Buffer, title string) {
+	fmt.Fprintf(b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", esc(title))
+	fmt.Fprintf(b, "<style>\n%s\n</style>\n</head>\n<body>\n<h1>%s</h1>\n", docCss, esc(title))
+}
+
+func (g *docGen) htmlMeta(b *bytes.Buffer) {
+	meta := g.idl.Meta
+	b.WriteString("<table>\n")
+	if meta.Checksum != "" {
+		fmt.Fprintf(b, "<tr><th>Checksum</th><td><code>%s</code></td></tr>\n", esc(meta.Checksum))
+	}
+	if at := g.generatedAt(); at != "" {
+		fmt.Fprintf(b, "<tr><th>Generated</th><td>%s</td></tr>\n", esc(at))
+	}
+	if meta.BarristerVersion != "" {
+		fmt.Fprintf(b, "<tr><th>Barrister version</th><td>%s</td></tr>\n", esc(meta.BarristerVersion))
+	}
+	b.WriteString("</table>\n")
+}
+
+// htmlElems writes a table of contents, then the elements, of namespace ns
+func (g *docGen) htmlElems(b *bytes.Buffer, ns string) {
+	elems := g.elems[ns]
+	if len(elems) == 0 {
+		return
+	}
+
+	b.WriteString("<ul>\n")
+	for _, el := range elems {
+		if el.Type != "comment" {
+			fmt.Fprintf(b, "<li>%s <a href=\"#%s\">%s</a></li>\n", el.Type, esc(el.Name), esc(el.Name))
+		}
+	}
+	b.WriteString("</ul>\n")
+
+	for _, el := range elems {
+		g.htmlElem(b, el)
+	}
+}
+
+func (g *docGen) htmlElem(b *bytes.Buffer, el IdlJsonElem) {
+	switch el.Type {
+	case "comment":
+		htmlComment(b, el.Value)
+	case "interface":
+		fmt.Fprintf(b, "<h2 id=\"%s\">interface %s</h2>\n", esc(el.Name), esc(el.Name))
+		htmlComment(b, el.Comment)
+		for _, fn := range el.Functions {
+			fmt.Fprintf(b, "<h3 id=\"%s.%s\">%s</h3>\n", esc(el.Name), esc(fn.Name), esc(fn.Name))
+			fmt.Fprintf(b, "<pre>%s</pre>\n", signature(fn, g.htmlLink, esc))
+			htmlComment(b, fn.Comment)
+			if len(fn.Params) > 0 {
+				b.WriteString("<table>\n<tr><th>Param</th><th>Type</th><th>Description</th></tr>\n")
+				for _, p := range fn.Params {
+					g.htmlFieldRow(b, p, "")
+				}
+				b.WriteString("</table>\n")
+			}
+			fmt.Fprintf(b, "<p>Returns: %s</p>\n", typeRef(fn.Returns, g.htmlLink, esc))
+		}
+	case "struct":
+		fmt.Fprintf(b, "<h2 id=\"%s\">struct %s</h2>\n", esc(el.Name), esc(el.Name))
+		htmlComment(b, el.Comment)
+		if chain := g.ancestors(el.Name); len(chain) > 0 {
+			links := []string{esc(el.Name)}
+			for _, name := range chain {
+				links = append(links, g.htmlLink(name))
+			}
+			fmt.Fprintf(b, "<p>Extends: %s</p>\n", strings.Join(links, " &rarr; "))
+		}
+		if subs := g.subtypes[el.Name]; len(subs) > 0 {
+			links := []string{}
+			for _, name := range subs {
+				links = append(links, g.htmlLink(name))
+			}
+			fmt.Fprintf(b, "<p>Extended by: %s</p>\n", strings.Join(links, ", "))
+		}
+		b.WriteString("<table>\n<tr><th>Field</th><th>Type</th><th>Description</th></tr>\n")
+		for _, f := range el.Fields {
+			g.htmlFieldRow(b, f, "")
+		}
+		for _, parent := range g.ancestors(el.Name) {
+			for _, f := range g.idl.structs[parent].Fields {
+				g.htmlFieldRow(b, f, parent)
+			}
+		}
+		b.WriteString("</table>\n")
+	case "enum":
+		fmt.Fprintf(b, "<h2 id=\"%s\">enum %s</h2>\n", esc(el.Name), esc(el.Name))
+		htmlComment(b, el.Comment)
+		b.WriteString("<table>\n<tr><th>Value</th><th>Description</th></tr>\n")
+		for _, v := range el.Values {
+			fmt.Fprintf(b, "<tr><td><code>%s</code></td><td class=\"comment\">%s</td></tr>\n", esc(v.Value), esc(v.Comment))
+		}
+		b.WriteString("</table>\n")
+	}
+}
+
+// htmlFieldRow writes a table row for f, which is inherited from the struct
+// named from, if not ""
+func (g *docGen) htmlFieldRow(b *bytes.Buffer, f Field, from string) {
+	desc := esc(f.Comment)
+	if from != "" {
+		desc = "(from " + g.htmlLink(from) + ") " + desc
+	}
+	fmt.Fprintf(b, "<tr><td>%s</td><td>%s</td><td class=\"comment\">%s</td></tr>\n",
+		esc(f.Name), typeRef(f, g.htmlLink, esc), desc)
+}
+
+func htmlComment(b *bytes.Buffer, comment string) {
+	if comment != "" {
+		fmt.Fprintf(b, "<p class=\"comment\">%s</p>\n", esc(comment))
+	}
+}
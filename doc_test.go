@@ -0,0 +1,83 @@
+package barrister
+
+import (
+	"strings"
+	"testing"
+)
+
+var nsIdlJson = `[{
+    "type": "struct", "name": "common.Address", "comment": "A postal <address>", "extends": "",
+    "fields": [{"name": "city", "type": "string", "optional": false, "is_array": false, "comment": ""}]
+}, {
+    "type": "struct", "name": "users.User", "comment": "", "extends": "",
+    "fields": [{"name": "addresses", "type": "common.Address", "optional": true, "is_array": true, "comment": "home first"}]
+}, {
+    "type": "interface", "name": "users.UserService", "comment": "Manages users",
+    "functions": [{
+        "name": "get", "comment": "@idempotent",
+        "params": [{"name": "id", "type": "string", "optional": false, "is_array": false, "comment": ""}],
+        "returns": {"name": "", "type": "users.User", "optional": true, "is_array": false, "comment": ""}
+    }]
+}, {
+    "type": "meta", "barrister_version": "0.1.6", "date_generated": 1400000000000, "checksum": "abc123"
+}]`
+
+func TestGenerateMarkdown(t *testing.T) {
+	md := string(parseTestIdl().GenerateMarkdown("Conform API"))
+
+	expected := []string{
+		"# Conform API\n",
+		"* Checksum: `34f6238ed03c6319017382e0fdc638a7`",
+		"* Generated: 2012-05-22T02:45:25Z",
+		"Barrister conformance IDL",
+		"### struct RepeatResponse\n\ntesting struct inheritance",
+		"Extends: RepeatResponse &rarr; [Response](#Response)",
+		"Extended by: [RepeatResponse](#RepeatResponse)",
+		"| status | [Status](#Status) | (from [Response](#Response)) |",
+		"    calc(nums []float, operation MathOp) float\n",
+		"| `multiply` | mult comment |",
+		"| email | string [optional] |",
+	}
+	for _, s := range expected {
+		if !strings.Contains(md, s) {
+			t.Errorf("Markdown does not contain: %q", s)
+		}
+	}
+}
+
+func TestGenerateHTML(t *testing.T) {
+	site := MustParseIdlJson([]byte(nsIdlJson)).GenerateHTML("Users <API>")
+	if len(site) != 3 {
+		t.Fatalf("expected index, common and users pages, got: %d", len(site))
+	}
+
+	index := string(site["index.html"])
+	for _, s := range []string{
+		"<title>Users &lt;API&gt;</title>",
+		"<code>abc123</code>",
+		"2014-05-13T16:53:20Z",
+		`<a href="common.html">common</a>`,
+		`<a href="users.html">users</a>`,
+	} {
+		if !strings.Contains(index, s) {
+			t.Errorf("index.html does not contain: %q", s)
+		}
+	}
+
+	users := string(site["users.html"])
+	for _, s := range []string{
+		`<h2 id="users.UserService">interface users.UserService</h2>`,
+		`<pre>get(id string) <a href="users.html#users.User">users.User</a> [optional]</pre>`,
+		`<td>[]<a href="common.html#common.Address">common.Address</a> [optional]</td>`,
+		`<p class="comment">@idempotent</p>`,
+	} {
+		if !strings.Contains(users, s) {
+			t.Errorf("users.html does not contain: %q", s)
+		}
+	}
+
+	common := string(site["common.html"])
+	if !strings.Contains(common, "A postal &lt;address&gt;") {
+		t.Errorf("comment not escaped in common.html")
+	}
+}
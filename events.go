@@ -0,0 +1,451 @@
+package barrister
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Events are notifications pushed from the server to subscribed clients over
+// a persistent connection (see Server.ServeConn and ConnTransport).
+//
+// An event is declared in the IDL as a function annotated with @event.  The
+// function params are the subscription arguments, and the return type is the
+// notification payload.  For example:
+//
+//     interface Ticker {
+//         // @event
+//         priceChanged(symbol string) Price
+//     }
+//
+// A client subscribes to Ticker.priceChanged with a symbol, and receives every
+// Price that the server publishes for that symbol:
+//
+//     svr.Publish("Ticker.priceChanged", price, "ACME")
+//
+// Subscriptions are managed with two reserved methods, handled by ServeConn:
+//
+//     barrister-subscribe   params: [subscription id, event method, [event params]]
+//     barrister-unsubscribe params: [subscription id]
+//
+// Each published event is sent to subscribers as an EventNotification.
+//
+// Subscribing passes through the Server's Filters as a call to the event
+// method, so subscriptions are authenticated, authorized, rate limited and
+// logged like other calls.
+
+// EventNotification is a JSON-RPC 2.0 notification (a request without an id)
+// sent to a client for each event published to one of its subscriptions.
+type EventNotification struct {
+	// Version of the JSON-RPC protocol.  Always "2.0"
+	Jsonrpc string `json:"jsonrpc"`
+
+	// Name of the IDL event function. e.g. "Ticker.priceChanged"
+	Method string `json:"method"`
+
+	Params EventParams `json:"params"`
+}
+
+// EventParams holds the subscription id and the event payload
+type EventParams struct {
+	// Id the client chose when subscribing
+	Subscription string `json:"subscription"`
+
+	// Event payload
+	Result interface{} `json:"result"`
+}
+
+// OverflowPolicy determines what Publish does when a subscriber's
+// notification queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowDrop discards the notification for the slow subscriber
+	OverflowDrop OverflowPolicy = iota
+
+	// OverflowBlock makes Publish wait for the slow subscriber until
+	// EventOptions.BlockTimeout elapses, and then discard the notification
+	OverflowBlock
+)
+
+// EventOptions configure delivery of events to subscribers
+type EventOptions struct {
+	// Number of notifications that may be queued per connection.
+	// Defaults to 100.
+	QueueSize int
+
+	// What to do when the queue is full
+	Overflow OverflowPolicy
+
+	// Used with OverflowBlock.  Zero means wait indefinitely.
+	BlockTimeout time.Duration
+}
+
+// eventHub tracks the subscriptions of all connections served by a Server
+type eventHub struct {
+	mu     sync.Mutex
+	opts   EventOptions
+	topics map[string]map[*eventSub]bool
+}
+
+// eventConn is a connection served by ServeConn.  Notifications are queued
+// and written by a separate goroutine so that Publish does not wait for
+// the network.
+type eventConn struct {
+	queue chan []byte
+	done  chan struct{}
+
+	// guarded by eventHub.mu
+	subs map[string]*eventSub
+}
+
+type eventSub struct {
+	id    string
+	topic string
+	conn  *eventConn
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{opts: EventOptions{QueueSize: 100}, topics: map[string]map[*eventSub]bool{}}
+}
+
+// SetEventOptions changes how events are delivered.  QueueSize applies to
+// connections accepted after the call.
+func (s *Server) SetEventOptions(opts EventOptions) {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 100
+	}
+	s.events.mu.Lock()
+	s.events.opts = opts
+	s.events.mu.Unlock()
+}
+
+// Publish sends payload to every client subscribed to the given event
+// function with params equal to the given params.  Publish returns the number
+// of subscribers the notification was queued for.  Subscribers whose queue
+// is full are handled according to EventOptions.Overflow.
+func (s *Server) Publish(method string, payload interface{}, params ...interface{}) (int, error) {
+	idlFunc, ok := s.idl.methods[method]
+	if !ok || !idlFunc.IsEvent() {
+		return 0, &JsonRpcError{Code: -32601, Message: fmt.Sprintf("Unsupported event: %s", method)}
+	}
+
+	topic, err := eventTopic(method, params)
+	if err != nil {
+		return 0, err
+	}
+
+	s.events.mu.Lock()
+	opts := s.events.opts
+	subs := make([]*eventSub, 0, len(s.events.topics[topic]))
+	for sub := range s.events.topics[topic] {
+		subs = append(subs, sub)
+	}
+	s.events.mu.Unlock()
+
+	queued := 0
+	for _, sub := range subs {
+		n := EventNotification{Jsonrpc: "2.0", Method: method,
+			Params: EventParams{Subscription: sub.id, Result: payload}}
+		b, err := s.ser.Marshal(n)
+		if err != nil {
+			return queued, err
+		}
+		if sub.conn.enqueue(b, opts) {
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// eventTopic returns the key that subscriptions and published events are
+// matched on: the method plus the canonical JSON encoding of the params
+func eventTopic(method string, params []interface{}) (string, error) {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	b, err := json.Marshal(params)
+	if err == nil {
+		var canonical interface{}
+		err = json.Unmarshal(b, &canonical)
+		if err == nil {
+			b, err = json.Marshal(canonical)
+		}
+	}
+	if err != nil {
+		msg := fmt.Sprintf("barrister: %s: unable to encode event params: %s", method, err)
+		return "", &JsonRpcError{Code: -32602, Message: msg}
+	}
+	return method + " " + string(b), nil
+}
+
+// openConn registers a connection and starts writing its notifications
+func (h *eventHub) openConn(write func(b []byte) error) *eventConn {
+	h.mu.Lock()
+	size := h.opts.QueueSize
+	h.mu.Unlock()
+
+	c := &eventConn{queue: make(chan []byte, size), done: make(chan struct{}),
+		subs: map[string]*eventSub{}}
+	go func() {
+		for {
+			select {
+			case b := <-c.queue:
+				// TODO: log err?
+				write(b)
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// closeConn removes all subscriptions of the connection
+func (h *eventHub) closeConn(c *eventConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for id := range c.subs {
+		h.remove(c, id)
+	}
+	close(c.done)
+}
+
+// invokeSubscription handles the barrister-subscribe and barrister-unsubscribe
+// methods.  A subscription passes through the Filters as a call to the event
+// method with the event params, so Filters such as AuthFilter and AuthzFilter
+// guard subscriptions like other IDL methods.  If a Filter stops the call,
+// the subscription is not registered.  Unsubscribing is not filtered, as it
+// can only remove a subscription of the same connection.
+func (s *Server) invokeSubscription(headers Headers, c *eventConn, rpcReq *JsonRpcRequest) *JsonRpcResponse {
+	resp := &JsonRpcResponse{Jsonrpc: "2.0", Id: rpcReq.Id}
+	params, _ := rpcReq.Params.([]interface{})
+
+	var result interface{} = true
+	var err error
+	if rpcReq.Method == "barrister-subscribe" {
+		result, err = s.subscribe(headers, c, params)
+	} else {
+		var id string
+		if len(params) == 1 {
+			id, _ = params[0].(string)
+		}
+		s.events.mu.Lock()
+		s.events.remove(c, id)
+		s.events.mu.Unlock()
+	}
+
+	if err != nil {
+		resp.Error = toJsonRpcError(rpcReq.Method, err)
+	} else {
+		resp.Result = result
+	}
+	return resp
+}
+
+// subscribe validates the barrister-subscribe params, runs the Filters and
+// registers the subscription
+func (s *Server) subscribe(headers Headers, c *eventConn, params []interface{}) (result interface{}, err error) {
+	if len(params) != 3 {
+		return nil, &JsonRpcError{Code: -32602,
+			Message: fmt.Sprintf("barrister-subscribe expects 3 params but was passed %d", len(params))}
+	}
+
+	id, _ := params[0].(string)
+	method, _ := params[1].(string)
+	eventParams, ok := params[2].([]interface{})
+	if id == "" || !ok && params[2] != nil {
+		return nil, &JsonRpcError{Code: -32602,
+			Message: "barrister-subscribe expects params: [subscription id, event method, [event params]]"}
+	}
+
+	idlFunc, ok := s.idl.methods[method]
+	if !ok || !idlFunc.IsEvent() {
+		return nil, &JsonRpcError{Code: -32601, Message: fmt.Sprintf("Unsupported event: %s", method)}
+	}
+	if len(idlFunc.Params) != len(eventParams) {
+		return nil, &JsonRpcError{Code: -32602,
+			Message: fmt.Sprintf("Event %s expects %d params but was passed %d", method, len(idlFunc.Params), len(eventParams))}
+	}
+
+	ctx := headers.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	headers.call = &callState{ctx: ctx}
+	defer func() { headers.call.done(err) }()
+
+	rr := &RequestResponse{Headers: headers, Method: method, Params: eventParams, Context: ctx}
+	for _, f := range s.filters {
+		if !f.PreInvoke(rr) {
+			return rr.Result, rr.Err
+		}
+	}
+
+	topic, err := eventTopic(method, rr.Params)
+	if err != nil {
+		return nil, err
+	}
+	s.events.add(c, id, topic)
+	rr.Result = true
+
+	for i := len(s.filters) - 1; i >= 0; i-- {
+		if !s.filters[i].PostInvoke(rr) {
+			break
+		}
+	}
+	if rr.Err != nil {
+		s.events.mu.Lock()
+		s.events.remove(c, id)
+		s.events.mu.Unlock()
+	}
+	return rr.Result, rr.Err
+}
+
+// add registers a subscription of c to topic, replacing any subscription
+// of c with the same id
+func (h *eventHub) add(c *eventConn, id string, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(c, id)
+	sub := &eventSub{id, topic, c}
+	c.subs[id] = sub
+	subs, ok := h.topics[topic]
+	if !ok {
+		subs = map[*eventSub]bool{}
+		h.topics[topic] = subs
+	}
+	subs[sub] = true
+}
+
+// remove deletes a subscription.  h.mu must be held.
+func (h *eventHub) remove(c *eventConn, id string) {
+	sub, ok := c.subs[id]
+	if !ok {
+		return
+	}
+
+	delete(c.subs, id)
+	delete(h.topics[sub.topic], sub)
+	if len(h.topics[sub.topic]) == 0 {
+		delete(h.topics, sub.topic)
+	}
+}
+
+// enqueue queues b for writing and returns true, or returns false if
+// the notification was discarded
+func (c *eventConn) enqueue(b []byte, opts EventOptions) bool {
+	if opts.Overflow != OverflowBlock {
+		select {
+		case c.queue <- b:
+			return true
+		case <-c.done:
+			return false
+		default:
+			return false
+		}
+	}
+
+	var timeout <-chan time.Time
+	if opts.BlockTimeout > 0 {
+		timer := time.NewTimer(opts.BlockTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case c.queue <- b:
+		return true
+	case <-c.done:
+		return false
+	case <-timeout:
+		return false
+	}
+}
+
+//////////////////////////////////////////////////
+// Client //
+////////////
+
+// NotificationTransport is implemented by Transports that can receive
+// notifications pushed by the server.
+type NotificationTransport interface {
+	// HandleNotifications calls handler with the serialized payload
+	// of each notification for the given subscription id
+	HandleNotifications(subscription string, handler func(result []byte))
+
+	// RemoveNotifications stops delivery of notifications for the
+	// given subscription id
+	RemoveNotifications(subscription string)
+}
+
+// SubscribeClient is implemented by Clients that can subscribe to events
+type SubscribeClient interface {
+	Subscribe(method string, handler func(payload interface{}), params ...interface{}) (*Subscription, error)
+}
+
+// Subscribe subscribes to an event function using c if it implements
+// SubscribeClient, or returns an error otherwise
+func Subscribe(c Client, method string, handler func(payload interface{}), params ...interface{}) (*Subscription, error) {
+	sc, ok := c.(SubscribeClient)
+	if !ok {
+		msg := fmt.Sprintf("barrister: %s: Client does not support subscriptions", method)
+		return nil, &JsonRpcError{Code: -32600, Message: msg}
+	}
+	return sc.Subscribe(method, handler, params...)
+}
+
+// Subscription is an active subscription to an event function
+type Subscription struct {
+	// Id identifies this subscription in notifications
+	Id string
+
+	// Event function. e.g. "Ticker.priceChanged"
+	Method string
+
+	client Client
+	trans  NotificationTransport
+}
+
+// Unsubscribe stops delivery of events to this subscription
+func (s *Subscription) Unsubscribe() error {
+	s.trans.RemoveNotifications(s.Id)
+	_, err := s.client.Call("barrister-unsubscribe", s.Id)
+	return err
+}
+
+// Subscribe subscribes to an event function.  handler is called with the
+// payload of each event the server publishes to the event function with the
+// given params.  The Transport must implement NotificationTransport.
+func (c *RemoteClient) Subscribe(method string, handler func(payload interface{}), params ...interface{}) (*Subscription, error) {
+	nt, ok := c.Trans.(NotificationTransport)
+	if !ok {
+		msg := fmt.Sprintf("barrister: %s: Transport does not support subscriptions", method)
+		return nil, &JsonRpcError{Code: -32600, Message: msg}
+	}
+
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	sub := &Subscription{Id: randHex(20), Method: method, client: c, trans: nt}
+	nt.HandleNotifications(sub.Id, func(result []byte) {
+		var payload interface{}
+		err := c.Ser.Unmarshal(result, &payload)
+		if err == nil {
+			handler(payload)
+		}
+	})
+
+	_, err := c.Call("barrister-subscribe", sub.Id, method, params)
+	if err != nil {
+		nt.RemoveNotifications(sub.Id)
+		return nil, err
+	}
+	return sub, nil
+}
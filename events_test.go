@@ -0,0 +1,139 @@
+package barrister
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newConnClient(svr *Server) (Client, *ConnTransport) {
+	serverConn, clientConn := net.Pipe()
+	go svr.ServeConn(serverConn, newHeaders())
+
+	trans := NewConnTransport(clientConn)
+	return NewRemoteClient(trans, false), trans
+}
+
+func TestEventSubscribe(t *testing.T) {
+	svr := newCounterServer()
+	client, trans := newConnClient(&svr)
+	defer trans.Close()
+
+	got := make(chan interface{}, 10)
+	sub, err := Subscribe(client, "Counter.changed", func(p interface{}) { got <- p }, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := svr.Publish("Counter.changed", 5, "b")
+	if err != nil || n != 0 {
+		t.Errorf("Publish to other topic: %d %v", n, err)
+	}
+
+	n, err = svr.Publish("Counter.changed", 7, "a")
+	if err != nil || n != 1 {
+		t.Errorf("Publish: %d %v", n, err)
+	}
+
+	select {
+	case p := <-got:
+		if p != 7.0 {
+			t.Errorf("payload %v != 7", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification not received")
+	}
+
+	err = sub.Unsubscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = svr.Publish("Counter.changed", 8, "a")
+	if err != nil || n != 0 {
+		t.Errorf("Publish after Unsubscribe: %d %v", n, err)
+	}
+
+	_, err = client.Call("Counter.changed", "a")
+	e, ok := err.(*JsonRpcError)
+	if !ok || e.Code != -32601 {
+		t.Errorf("Call on event didn't fail: %v", err)
+	}
+
+	_, err = Subscribe(client, "Counter.total", func(p interface{}) {})
+	e, ok = err.(*JsonRpcError)
+	if !ok || e.Code != -32601 {
+		t.Errorf("Subscribe to non-event didn't fail: %v", err)
+	}
+}
+
+func TestEventSubscribeFiltered(t *testing.T) {
+	svr, _ := newAuthServer(NewAuthFilter(&BearerAuthenticator{Verify: func(token string) (*Principal, error) {
+		return &Principal{Id: token}, nil
+	}}))
+
+	// connection without credentials
+	client, trans := newConnClient(&svr)
+	defer trans.Close()
+	_, err := Subscribe(client, "Counter.changed", func(p interface{}) {}, "a")
+	expectAuthError(t, err)
+	n, _ := svr.Publish("Counter.changed", 1, "a")
+	if n != 0 {
+		t.Errorf("rejected subscription received event")
+	}
+
+	serverConn, clientConn := net.Pipe()
+	go svr.ServeConn(serverConn, bearerHeaders("dave"))
+	trans = NewConnTransport(clientConn)
+	defer trans.Close()
+	_, err = Subscribe(NewRemoteClient(trans, false), "Counter.changed", func(p interface{}) {}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ = svr.Publish("Counter.changed", 1, "a")
+	if n != 1 {
+		t.Errorf("Publish to authenticated subscription: %d", n)
+	}
+}
+
+func TestEventSubscribeRequiresPersistentTransport(t *testing.T) {
+	svr := newCounterServer()
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	client := NewRemoteClient(&HttpTransport{Url: ts.URL}, true)
+	_, err := Subscribe(client, "Counter.changed", func(p interface{}) {}, "a")
+	if err == nil {
+		t.Errorf("Subscribe over HTTP didn't fail")
+	}
+}
+
+func TestEventOverflowDrop(t *testing.T) {
+	svr := newCounterServer()
+	svr.SetEventOptions(EventOptions{QueueSize: 1, Overflow: OverflowDrop})
+	client, trans := newConnClient(&svr)
+	defer trans.Close()
+
+	release := make(chan bool)
+	_, err := Subscribe(client, "Counter.changed", func(p interface{}) { <-release }, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(release)
+
+	// the handler never returns, so the client eventually stops reading and
+	// the server's queue for this connection fills up
+	queued := 0
+	for i := 0; i < 200; i++ {
+		n, err := svr.Publish("Counter.changed", i, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued += n
+		time.Sleep(time.Millisecond)
+	}
+
+	if queued == 0 || queued == 200 {
+		t.Errorf("expected some notifications to be dropped. queued=%d", queued)
+	}
+}
@@ -90,6 +90,7 @@ func (g *generateGo) generate() []byte {
 		for _, name := range sortedKeys(g.pkgIdl.interfaces) {
 			g.generateInterface(b, name)
 			line(b, 0, "}\n")
+			g.generateStreamTypes(b, name)
 			g.generateProxy(b, name)
 		}
 
@@ -117,6 +118,7 @@ func (g *generateGo) generateEnum(b *bytes.Buffer, enumName string) {
 	}
 
 	goName := capitalizeAndStripMatchingPkg(enumName, g.pkgName)
+	docComment(b, 0, goName, "enum", enumName, g.elemComment("enum", enumName))
 	line(b, 0, fmt.Sprintf("type %s string", goName))
 	line(b, 0, "const (")
 	for x, val := range vals {
@@ -124,6 +126,7 @@ func (g *generateGo) generateEnum(b *bytes.Buffer, enumName string) {
 		if x == 0 {
 			typeStr = goName
 		}
+		docComment(b, 1, goName+capitalize(val.Value), "enum value", enumName+"."+val.Value, val.Comment)
 		line(b, 1, fmt.Sprintf("%s%s %s = \"%s\"",
 			goName, capitalize(val.Value), typeStr, val.Value))
 	}
@@ -132,12 +135,14 @@ func (g *generateGo) generateEnum(b *bytes.Buffer, enumName string) {
 
 func (g *generateGo) generateStruct(b *bytes.Buffer, s *Struct) {
 	goName := capitalizeAndStripMatchingPkg(s.Name, g.pkgName)
+	docComment(b, 0, goName, "struct", s.Name, g.elemComment("struct", s.Name))
 	line(b, 0, fmt.Sprintf("type %s struct {", goName))
 	if s.Extends != "" {
 		line(b, 1, capitalizeAndStripMatchingPkg(s.Extends, g.pkgName))
 	}
 	for _, f := range s.Fields {
 		goName = capitalize(f.Name)
+		docComment(b, 1, goName, "field", s.Name+"."+f.Name, f.Comment)
 		omit := ""
 		if f.Optional {
 			omit = ",omitempty"
@@ -180,9 +185,15 @@ func (g *generateGo) generateInterface(b *bytes.Buffer, ifaceName string) {
 	}
 
 	goName := capitalize(ifaceName)
+	docComment(b, 0, goName, "interface", ifaceName, g.elemComment("interface", ifaceName))
 	line(b, 0, fmt.Sprintf("type %s interface {", goName))
 	for _, fn := range funcs {
+		if fn.IsEvent() {
+			continue
+		}
+
 		goName = capitalize(fn.Name)
+		docComment(b, 1, goName, "function", ifaceName+"."+fn.Name, fn.Comment)
 		params := ""
 		for x, p := range fn.Params {
 			if x > 0 {
@@ -190,9 +201,176 @@ func (g *generateGo) generateInterface(b *bytes.Buffer, ifaceName string) {
 			}
 			params += fmt.Sprintf("%s %s", escReserved(p.Name), p.goType(g.idl, g.optionalToPtr, g.pkgName))
 		}
-		line(b, 1, fmt.Sprintf("%s(%s) (%s, error)",
-			goName, params, fn.Returns.goType(g.idl, g.optionalToPtr, g.pkgName)))
+		if fn.IsStream() {
+			if params != "" {
+				params += ", "
+			}
+			line(b, 1, fmt.Sprintf("%s(%sout %s) error",
+				goName, params, streamTypeName(ifaceName, fn, "Sender")))
+		} else {
+			line(b, 1, fmt.Sprintf("%s(%s) (%s, error)",
+				goName, params, fn.Returns.goType(g.idl, g.optionalToPtr, g.pkgName)))
+		}
+	}
+}
+
+// generateStreamTypes writes the sender func type and iterator for each
+// stream function on the interface
+func (g *generateGo) generateStreamTypes(b *bytes.Buffer, ifaceName string) {
+	for _, fn := range g.idl.interfaces[ifaceName] {
+		if !fn.IsStream() {
+			continue
+		}
+
+		method := fmt.Sprintf("%s.%s", ifaceName, fn.Name)
+		item := fn.streamItem()
+		itemType := item.goType(g.idl, g.optionalToPtr, g.pkgName)
+		zeroVal := item.zeroVal(g.idl, g.optionalToPtr, g.pkgName)
+		senderName := streamTypeName(ifaceName, fn, "Sender")
+		streamName := streamTypeName(ifaceName, fn, "Stream")
+
+		comment(b, 0, fmt.Sprintf("%s is passed to the handler of IDL function %s, which\ncalls it with each item of the result.", senderName, method))
+		line(b, 0, fmt.Sprintf("type %s func(item %s) error\n", senderName, itemType))
+
+		comment(b, 0, fmt.Sprintf("%s iterates over the items of a call to IDL function %s.", streamName, method))
+		line(b, 0, fmt.Sprintf("type %s struct {", streamName))
+		line(b, 1, "it    *barrister.StreamIterator")
+		line(b, 1, "idl   *barrister.Idl")
+		line(b, 1, "field barrister.Field")
+		line(b, 1, fmt.Sprintf("item  %s", itemType))
+		line(b, 1, "err   error")
+		line(b, 0, "}\n")
+
+		line(b, 0, fmt.Sprintf("func (_s *%s) Next() bool {", streamName))
+		line(b, 1, "if _s.err != nil || !_s.it.Next() {")
+		line(b, 2, "return false")
+		line(b, 1, "}")
+		line(b, 1, fmt.Sprintf("_res, _err := barrister.Convert(_s.idl, &_s.field, reflect.TypeOf(%s), _s.it.Item(), \"\")", zeroVal))
+		line(b, 1, "if _err == nil {")
+		line(b, 2, fmt.Sprintf("_cast, _ok := _res.(%s)", itemType))
+		line(b, 2, "if _ok {")
+		line(b, 3, "_s.item = _cast")
+		line(b, 3, "return true")
+		line(b, 2, "}")
+		line(b, 2, "_t := reflect.TypeOf(_res)")
+		line(b, 2, `_msg := fmt.Sprintf("`+method+` returned invalid type: %v", _t)`)
+		line(b, 2, "_err = &barrister.JsonRpcError{Code: -32000, Message: _msg}")
+		line(b, 1, "}")
+		line(b, 1, "_s.err = _err")
+		line(b, 1, "_s.it.Close()")
+		line(b, 1, "return false")
+		line(b, 0, "}\n")
+
+		line(b, 0, fmt.Sprintf("func (_s *%s) Item() %s { return _s.item }\n", streamName, itemType))
+
+		line(b, 0, fmt.Sprintf("func (_s *%s) Err() error {", streamName))
+		line(b, 1, "if _s.err != nil {")
+		line(b, 2, "return _s.err")
+		line(b, 1, "}")
+		line(b, 1, "return _s.it.Err()")
+		line(b, 0, "}\n")
+
+		line(b, 0, fmt.Sprintf("func (_s *%s) Close() error { return _s.it.Close() }\n", streamName))
+	}
+}
+
+// generateStreamProxy writes the proxy methods for a stream function.  The
+// method that implements the interface passes each item to the sender,
+// and a second method ending in "Stream" returns an iterator.
+func (g *generateGo) generateStreamProxy(b *bytes.Buffer, ifaceName string, proxyName string, fn Function) {
+	method := fmt.Sprintf("%s.%s", ifaceName, fn.Name)
+	fnName := capitalize(fn.Name)
+	streamName := streamTypeName(ifaceName, fn, "Stream")
+	params := ""
+	paramIdents := ""
+	for x, p := range fn.Params {
+		if x > 0 {
+			params += ", "
+			paramIdents += ", "
+		}
+		ident := escReserved(p.Name)
+		params += fmt.Sprintf("%s %s", ident, p.goType(g.idl, g.optionalToPtr, g.pkgName))
+		paramIdents += ident
+	}
+
+	sep := ""
+	if params != "" {
+		sep = ", "
+	}
+
+	docComment(b, 0, fnName, "function", method, fn.Comment)
+	line(b, 0, fmt.Sprintf("func (_p %s) %s(%s%sout %s) error {",
+		proxyName, fnName, params, sep, streamTypeName(ifaceName, fn, "Sender")))
+	line(b, 1, fmt.Sprintf("_s, _err := _p.%sStream(%s)", fnName, paramIdents))
+	line(b, 1, "if _err != nil {")
+	line(b, 2, "return _err")
+	line(b, 1, "}")
+	line(b, 1, "defer _s.Close()")
+	line(b, 1, "for _s.Next() {")
+	line(b, 2, "_err = out(_s.Item())")
+	line(b, 2, "if _err != nil {")
+	line(b, 3, "return _err")
+	line(b, 2, "}")
+	line(b, 1, "}")
+	line(b, 1, "return _s.Err()")
+	line(b, 0, "}\n")
+
+	comment(b, 0, fmt.Sprintf("%sStream calls IDL function %s and returns an iterator over\nthe items of the result.", fnName, method))
+	line(b, 0, fmt.Sprintf("func (_p %s) %sStream(%s) (*%s, error) {",
+		proxyName, fnName, params, streamName))
+	line(b, 1, fmt.Sprintf("_it, _err := barrister.CallStream(_p.client, \"%s\"%s%s)", method, sep, paramIdents))
+	line(b, 1, "if _err != nil {")
+	line(b, 2, "return nil, _err")
+	line(b, 1, "}")
+	line(b, 1, fmt.Sprintf("_field := _p.idl.Method(\"%s\").Returns", method))
+	line(b, 1, "_field.IsArray, _field.Optional = false, false")
+	line(b, 1, fmt.Sprintf("return &%s{it: _it, idl: _p.idl, field: _field}, nil", streamName))
+	line(b, 0, "}\n")
+}
+
+// generateEventProxy writes the typed subscribe and unsubscribe methods for
+// an event function.  Payloads that cannot be converted to the IDL return type
+// are not passed to the handler.
+func (g *generateGo) generateEventProxy(b *bytes.Buffer, ifaceName string, proxyName string, fn Function) {
+	method := fmt.Sprintf("%s.%s", ifaceName, fn.Name)
+	fnName := capitalize(fn.Name)
+	retType := fn.Returns.goType(g.idl, g.optionalToPtr, g.pkgName)
+	zeroVal := fn.Returns.zeroVal(g.idl, g.optionalToPtr, g.pkgName)
+	params := ""
+	paramIdents := ""
+	for _, p := range fn.Params {
+		ident := escReserved(p.Name)
+		params += fmt.Sprintf("%s %s, ", ident, p.goType(g.idl, g.optionalToPtr, g.pkgName))
+		paramIdents += ", " + ident
 	}
+
+	docComment(b, 0, "Subscribe"+fnName, "event", method, fn.Comment)
+	line(b, 0, fmt.Sprintf("func (_p %s) Subscribe%s(%shandler func(%s)) (*barrister.Subscription, error) {",
+		proxyName, fnName, params, retType))
+	line(b, 1, fmt.Sprintf("_retType := _p.idl.Method(\"%s\").Returns", method))
+	line(b, 1, "_handler := func(_payload interface{}) {")
+	line(b, 2, fmt.Sprintf("_res, _err := barrister.Convert(_p.idl, &_retType, reflect.TypeOf(%s), _payload, \"\")", zeroVal))
+	line(b, 2, "if _err == nil {")
+	line(b, 3, fmt.Sprintf("_cast, _ok := _res.(%s)", retType))
+	line(b, 3, "if _ok {")
+	line(b, 4, "handler(_cast)")
+	line(b, 3, "}")
+	line(b, 2, "}")
+	line(b, 1, "}")
+	line(b, 1, fmt.Sprintf("return barrister.Subscribe(_p.client, \"%s\", _handler%s)", method, paramIdents))
+	line(b, 0, "}\n")
+
+	comment(b, 0, fmt.Sprintf("Unsubscribe%s cancels a subscription to IDL event %s.", fnName, method))
+	line(b, 0, fmt.Sprintf("func (_p %s) Unsubscribe%s(sub *barrister.Subscription) error {",
+		proxyName, fnName))
+	line(b, 1, "return sub.Unsubscribe()")
+	line(b, 0, "}\n")
+}
+
+// streamTypeName returns the name of a generated type for a stream function.
+// e.g. "ARepeat_numSender" for function "repeat_num" on interface "A"
+func streamTypeName(ifaceName string, fn Function, suffix string) string {
+	return capitalize(ifaceName) + capitalize(fn.Name) + suffix
 }
 
 func (g *generateGo) generateProxy(b *bytes.Buffer, ifaceName string) {
@@ -204,13 +382,37 @@ func (g *generateGo) generateProxy(b *bytes.Buffer, ifaceName string) {
 	goIfaceName := capitalize(ifaceName)
 	goName := goIfaceName + "Proxy"
 
-	line(b, 0, fmt.Sprintf("func New%s(c barrister.Client) %s { return %s{c, barrister.MustParseIdlJson([]byte(IdlJsonRaw))} }\n", goName, goIfaceName, goName))
+	comment(b, 0, fmt.Sprintf("New%s returns %s %s that calls the server through c.", goName, article(goName), goName))
+	line(b, 0, fmt.Sprintf("func New%s(c barrister.Client) %s { return %s{c, barrister.MustParseIdlJson([]byte(IdlJsonRaw))} }\n", goName, goName, goName))
 
+	proxyComment := fmt.Sprintf("%s implements %s by calling IDL interface %s on a\nremote server.", goName, goIfaceName, ifaceName)
+	for _, fn := range funcs {
+		if fn.IsStream() {
+			proxyComment += fmt.Sprintf("\nStream functions also have a method ending in \"Stream\" that\nreturns an iterator, e.g. %sStream.", capitalize(fn.Name))
+			break
+		}
+	}
+	for _, fn := range funcs {
+		if fn.IsEvent() {
+			proxyComment += fmt.Sprintf("\nEvents are subscribed to with methods that are not part of %s,\ne.g. Subscribe%s.", goIfaceName, capitalize(fn.Name))
+			break
+		}
+	}
+	comment(b, 0, proxyComment)
 	line(b, 0, fmt.Sprintf("type %s struct {", goName))
 	line(b, 1, "client barrister.Client")
 	line(b, 1, "idl    *barrister.Idl")
 	line(b, 0, "}\n")
 	for _, fn := range funcs {
+		if fn.IsStream() {
+			g.generateStreamProxy(b, ifaceName, goName, fn)
+			continue
+		}
+		if fn.IsEvent() {
+			g.generateEventProxy(b, ifaceName, goName, fn)
+			continue
+		}
+
 		method := fmt.Sprintf("%s.%s", ifaceName, fn.Name)
 		retType := fn.Returns.goType(g.idl, g.optionalToPtr, g.pkgName)
 		zeroVal := fn.Returns.zeroVal(g.idl, g.optionalToPtr, g.pkgName)
@@ -226,6 +428,7 @@ func (g *generateGo) generateProxy(b *bytes.Buffer, ifaceName string) {
 			paramIdents += ", "
 			paramIdents += ident
 		}
+		docComment(b, 0, fnName, "function", method, fn.Comment)
 		line(b, 0, fmt.Sprintf("func (_p %s) %s(%s) (%s, error) {",
 			goName, fnName, params, retType))
 		line(b, 1, fmt.Sprintf("_res, _err := _p.client.Call(\"%s\"%s)",
@@ -253,12 +456,62 @@ func (g *generateGo) generateProxy(b *bytes.Buffer, ifaceName string) {
 	}
 }
 
+// article returns the indefinite article for name, going by its first
+// letter: "an" for AProxy or EventsProxy, "a" for CounterProxy
+func article(name string) string {
+	if name != "" && strings.ContainsRune("AEIOU", rune(name[0])) {
+		return "an"
+	}
+	return "a"
+}
+
 func comment(b *bytes.Buffer, level int, comment string) {
 	if comment != "" {
 		for _, ln := range strings.Split(comment, "\n") {
-			line(b, level, fmt.Sprintf("// %s", ln))
+			ln = strings.TrimRight(ln, " \t")
+			if ln == "" {
+				line(b, level, "//")
+			} else {
+				line(b, level, fmt.Sprintf("// %s", ln))
+			}
+		}
+	}
+}
+
+// docComment writes the doc comment of goName, which is generated from the
+// IDL element idlName of the given kind.  The IDL comment, without its
+// annotations, follows the first line, and a @deprecated annotation becomes
+// a "Deprecated:" paragraph.
+func docComment(b *bytes.Buffer, level int, goName string, kind string, idlName string, idlComment string) {
+	text := fmt.Sprintf("%s is generated from IDL %s %s.", goName, kind, idlName)
+
+	body := []string{}
+	for _, ln := range strings.Split(idlComment, "\n") {
+		if !isAnnotation(ln) {
+			body = append(body, ln)
+		}
+	}
+	if s := strings.TrimSpace(strings.Join(body, "\n")); s != "" {
+		text += "\n\n" + s
+	}
+
+	if reason, ok := parseAnnotations(idlComment)["deprecated"]; ok {
+		if reason == "" {
+			reason = fmt.Sprintf("%s is deprecated.", idlName)
+		}
+		text += "\n\nDeprecated: " + reason
+	}
+	comment(b, level, text)
+}
+
+// elemComment returns the comment of the named IDL element of the given type
+func (g *generateGo) elemComment(elemType string, name string) string {
+	for _, el := range g.idl.elems {
+		if el.Type == elemType && el.Name == name {
+			return el.Comment
 		}
 	}
+	return ""
 }
 
 func line(b *bytes.Buffer, level int, s string) {
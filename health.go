@@ -0,0 +1,218 @@
+package barrister
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthCheck reports whether a dependency of the service is healthy.  It
+// returns nil if healthy.  Checks should return promptly once ctx is done.
+type HealthCheck func(ctx context.Context) error
+
+// HealthStatus is the result of running the health checks of a Server
+type HealthStatus struct {
+	// True if all liveness checks passed.  A service that is not live should
+	// be restarted.
+	Live bool `json:"live"`
+
+	// True if the service is live, all readiness checks passed, and the
+	// Server is not shutting down.  A service that is not ready should not be
+	// sent requests.
+	Ready bool `json:"ready"`
+
+	// Result of each check that was run, by name
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// CheckResult is the result of a single HealthCheck
+type CheckResult struct {
+	Ok bool `json:"ok"`
+
+	// Error returned by the check, if it failed
+	Error string `json:"error,omitempty"`
+
+	// Time the check took, in milliseconds
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// DefaultHealthTimeout bounds the time the health checks run by
+// barrister-health and HealthHandler may take
+var DefaultHealthTimeout = 5 * time.Second
+
+// healthState holds the health checks of a Server.  It is shared by copies
+// of the Server.
+type healthState struct {
+	mu       sync.Mutex
+	liveness map[string]HealthCheck
+	ready    map[string]HealthCheck
+}
+
+func newHealthState() *healthState {
+	return &healthState{liveness: map[string]HealthCheck{}, ready: map[string]HealthCheck{}}
+}
+
+// AddHealthCheck registers a readiness check, replacing any check with the
+// same name.  Readiness checks test what the service needs to handle
+// requests, e.g. a database connection.
+func (s *Server) AddHealthCheck(name string, check HealthCheck) {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	delete(s.health.liveness, name)
+	s.health.ready[name] = check
+}
+
+// AddLivenessCheck registers a liveness check, replacing any check with the
+// same name.  Liveness checks should only fail if the process can not
+// recover without a restart, e.g. a deadlocked worker.
+func (s *Server) AddLivenessCheck(name string, check HealthCheck) {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	delete(s.health.ready, name)
+	s.health.liveness[name] = check
+}
+
+// RemoveHealthCheck removes the readiness or liveness check with the given name
+func (s *Server) RemoveHealthCheck(name string) {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	delete(s.health.liveness, name)
+	delete(s.health.ready, name)
+}
+
+// Health runs the health checks concurrently and returns their aggregate
+// status.  If liveOnly is true, readiness checks are not run and Ready is
+// false.  A check that has not returned when ctx is done fails.
+func (s *Server) Health(ctx context.Context, liveOnly bool) HealthStatus {
+	s.health.mu.Lock()
+	checks := map[string]HealthCheck{}
+	liveness := map[string]bool{}
+	for name, check := range s.health.liveness {
+		checks[name] = check
+		liveness[name] = true
+	}
+	if !liveOnly {
+		for name, check := range s.health.ready {
+			checks[name] = check
+		}
+	}
+	s.health.mu.Unlock()
+
+	type named struct {
+		name   string
+		result CheckResult
+	}
+	results := make(chan named, len(checks))
+	for name, check := range checks {
+		go func(name string, check HealthCheck) {
+			results <- named{name, runCheck(ctx, check)}
+		}(name, check)
+	}
+
+	status := HealthStatus{Live: true, Ready: !liveOnly && !s.drain.isClosing(),
+		Checks: map[string]CheckResult{}}
+	for len(status.Checks) < len(checks) {
+		var n named
+		select {
+		case n = <-results:
+		case <-ctx.Done():
+			// report the checks that have not returned as failed
+			for name := range checks {
+				if _, ok := status.Checks[name]; !ok {
+					status.Checks[name] = CheckResult{Error: ctx.Err().Error()}
+					status.Live = status.Live && !liveness[name]
+					status.Ready = false
+				}
+			}
+			continue
+		}
+		status.Checks[n.name] = n.result
+		if !n.result.Ok {
+			status.Live = status.Live && !liveness[n.name]
+			status.Ready = false
+		}
+	}
+	status.Ready = status.Ready && status.Live
+	return status
+}
+
+// runCheck runs check, recovering a panic as a failure
+func runCheck(ctx context.Context, check HealthCheck) (result CheckResult) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			result = CheckResult{Error: fmt.Sprintf("panic: %v", r)}
+		}
+		result.DurationMs = time.Since(start).Milliseconds()
+	}()
+
+	err := check(ctx)
+	if err != nil {
+		return CheckResult{Error: err.Error()}
+	}
+	return CheckResult{Ok: true}
+}
+
+// invokeHealth handles the reserved "barrister-health" method.  Its
+// optional param is "live" or "ready" (the default).
+func (s *Server) invokeHealth(headers Headers, rpcReq *JsonRpcRequest) *JsonRpcResponse {
+	probe := "ready"
+	if arr, ok := rpcReq.Params.([]interface{}); ok && len(arr) > 0 {
+		probe, _ = arr[0].(string)
+	}
+	if probe != "live" && probe != "ready" {
+		return &JsonRpcResponse{Jsonrpc: "2.0", Id: rpcReq.Id, Error: &JsonRpcError{Code: -32602,
+			Message: fmt.Sprintf("barrister: barrister-health param must be \"live\" or \"ready\", got: %v", rpcReq.Params)}}
+	}
+
+	ctx := headers.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, DefaultHealthTimeout)
+	defer cancel()
+	return &JsonRpcResponse{Jsonrpc: "2.0", Id: rpcReq.Id, Result: s.Health(ctx, probe == "live")}
+}
+
+// HealthHandler returns an http.Handler for load balancer and orchestrator
+// probes.  GET requests whose path ends in "/live" run the liveness checks,
+// and other GET requests run all checks.  The response is the HealthStatus
+// as JSON, with status 200 if live (or ready), and 503 otherwise.
+//
+//     http.Handle("/rpc", &svr)
+//     http.Handle("/health/", svr.HealthHandler())
+//
+func (s *Server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		liveOnly := strings.HasSuffix(strings.TrimSuffix(req.URL.Path, "/"), "/live")
+		ctx, cancel := context.WithTimeout(req.Context(), DefaultHealthTimeout)
+		defer cancel()
+		status := s.Health(ctx, liveOnly)
+
+		code := http.StatusOK
+		if !status.Live || (!liveOnly && !status.Ready) {
+			code = http.StatusServiceUnavailable
+		}
+
+		b, err := json.Marshal(status)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		if req.Method == "GET" {
+			w.Write(b)
+		}
+	})
+}
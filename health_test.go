@@ -0,0 +1,154 @@
+package barrister
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthAggregates(t *testing.T) {
+	svr := newCounterServer()
+	dbErr := errors.New("connection refused")
+	var dbDown bool
+	svr.AddHealthCheck("db", func(ctx context.Context) error {
+		if dbDown {
+			return dbErr
+		}
+		return nil
+	})
+	svr.AddLivenessCheck("worker", func(ctx context.Context) error { return nil })
+
+	status := svr.Health(context.Background(), false)
+	if !status.Live || !status.Ready || len(status.Checks) != 2 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	dbDown = true
+	status = svr.Health(context.Background(), false)
+	if !status.Live || status.Ready || status.Checks["db"].Error != dbErr.Error() {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	status = svr.Health(context.Background(), true)
+	if !status.Live || status.Ready || len(status.Checks) != 1 {
+		t.Errorf("unexpected liveness status: %+v", status)
+	}
+
+	svr.AddLivenessCheck("worker", func(ctx context.Context) error { panic("stuck") })
+	status = svr.Health(context.Background(), true)
+	if status.Live || status.Checks["worker"].Ok {
+		t.Errorf("panicking check passed: %+v", status)
+	}
+
+	svr.RemoveHealthCheck("worker")
+	svr.RemoveHealthCheck("db")
+	status = svr.Health(context.Background(), false)
+	if !status.Live || !status.Ready || len(status.Checks) != 0 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	svr := newCounterServer()
+	svr.AddHealthCheck("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	status := svr.Health(ctx, false)
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Health did not return when ctx was done: %s", time.Since(start))
+	}
+	if status.Ready || status.Checks["slow"].Ok {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestHealthMethod(t *testing.T) {
+	svr := newCounterServer()
+	svr.AddHealthCheck("db", func(ctx context.Context) error { return errors.New("down") })
+
+	resp := svr.InvokeOne(newHeaders(), &JsonRpcRequest{Jsonrpc: "2.0", Id: "1", Method: "barrister-health"})
+	status, ok := resp.Result.(HealthStatus)
+	if !ok || !status.Live || status.Ready {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	resp = svr.InvokeOne(newHeaders(), &JsonRpcRequest{Jsonrpc: "2.0", Id: "2", Method: "barrister-health",
+		Params: []interface{}{"live"}})
+	status, ok = resp.Result.(HealthStatus)
+	if !ok || !status.Live || len(status.Checks) != 0 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	resp = svr.InvokeOne(newHeaders(), &JsonRpcRequest{Jsonrpc: "2.0", Id: "3", Method: "barrister-health",
+		Params: []interface{}{"bogus"}})
+	if resp.Error == nil || resp.Error.Code != -32602 {
+		t.Errorf("expected -32602 error, got: %+v", resp)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	svr := newCounterServer()
+	var dbDown bool
+	svr.AddHealthCheck("db", func(ctx context.Context) error {
+		if dbDown {
+			return errors.New("down")
+		}
+		return nil
+	})
+	ts := httptest.NewServer(svr.HealthHandler())
+	defer ts.Close()
+
+	get := func(path string) (int, HealthStatus) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		status := HealthStatus{}
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, status
+	}
+
+	code, status := get("/health/ready")
+	if code != 200 || !status.Ready {
+		t.Errorf("unexpected response: %d %+v", code, status)
+	}
+
+	dbDown = true
+	code, status = get("/health/ready")
+	if code != 503 || status.Ready {
+		t.Errorf("unexpected response: %d %+v", code, status)
+	}
+	code, status = get("/health/live")
+	if code != 200 || !status.Live {
+		t.Errorf("unexpected response: %d %+v", code, status)
+	}
+
+	dbDown = false
+	svr.Shutdown(context.Background())
+	code, status = get("/health/ready")
+	if code != 503 || status.Ready {
+		t.Errorf("server shutting down but ready: %d %+v", code, status)
+	}
+
+	resp, err := http.Post(ts.URL+"/health/ready", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("status %d != 405", resp.StatusCode)
+	}
+}
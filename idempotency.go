@@ -0,0 +1,267 @@
+package barrister
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the request header that carries the idempotency
+// key of a call.  Clients without headers may set JsonRpcRequest.IdempotencyKey.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ErrCodeIdempotencyConflict is the JsonRpcError code returned by
+// IdempotencyFilter when a request with the same idempotency key is still in
+// progress, or when a key is reused with different params
+const ErrCodeIdempotencyConflict = -32004
+
+// IdempotentResponse is the stored response of a call with an idempotency key
+type IdempotentResponse struct {
+	// Hash of the call's params, to detect keys reused with different params
+	ParamsHash string
+
+	// Result encoded as JSON.  Not set if Err is set.
+	Result json.RawMessage
+
+	Err *JsonRpcError
+}
+
+// IdempotencyStore stores responses for IdempotencyFilter.
+// Implementations must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Reserve claims key for a new call until ttl elapses, and returns true.
+	// If key is already claimed, Reserve returns false and the stored
+	// response, or nil if the call that claimed key has not completed.
+	Reserve(key string, ttl time.Duration) (*IdempotentResponse, bool)
+
+	// Complete stores the response for a reserved key until ttl elapses
+	Complete(key string, resp *IdempotentResponse, ttl time.Duration)
+
+	// Release discards a reserved key that has no stored response, so the
+	// call may be made again
+	Release(key string)
+}
+
+// IdempotencyFilter is a Filter that makes calls with an idempotency key
+// (see IdempotencyKeyHeader) run at most once.  The first response for a key
+// is stored for Window, and returned to later calls with the same key instead
+// of calling the handler again.  Keys are scoped to the method and the
+// caller's Principal.
+//
+// Calls with a key that is still in progress, or that was used with
+// different params, fail with code ErrCodeIdempotencyConflict.  Responses
+// with code -32603, ErrCodeTimeout or ErrCodeShuttingDown are not stored, so
+// the call may be retried.  The key of a call that timed out is held until
+// its abandoned handler returns.  Stream
+// functions and functions annotated @idempotent are not affected.
+//
+// Register IdempotencyFilter after any AuthFilter, and before the Filters
+// whose effects should not be repeated.
+//
+//     svr.AddFilter(barrister.NewIdempotencyFilter(idl, barrister.NewMemoryIdempotencyStore()))
+//
+type IdempotencyFilter struct {
+	// How long responses are stored.  Defaults to 24 hours.
+	Window time.Duration
+
+	idl   *Idl
+	store IdempotencyStore
+}
+
+// idempotentCall is the state of a call with an idempotency key
+type idempotentCall struct {
+	key        string
+	paramsHash string
+	completed  bool
+}
+
+type idempotentCallKey struct{}
+
+// NewIdempotencyFilter creates an IdempotencyFilter that stores responses in store
+func NewIdempotencyFilter(idl *Idl, store IdempotencyStore) *IdempotencyFilter {
+	return &IdempotencyFilter{Window: 24 * time.Hour, idl: idl, store: store}
+}
+
+// PreInvoke returns the stored response for the call's key, if any
+func (f *IdempotencyFilter) PreInvoke(r *RequestResponse) bool {
+	key := GetFirst(r.Headers.Request, IdempotencyKeyHeader)
+	fn := f.idl.methods[r.Method]
+	if key == "" || fn.IsStream() || f.idl.IsIdempotent(r.Method) {
+		return true
+	}
+
+	b, err := json.Marshal(r.Params)
+	if err != nil {
+		return true
+	}
+	sum := sha256.Sum256(b)
+	call := &idempotentCall{key: r.Method + "\n" + principalId(r) + "\n" + key, paramsHash: hex.EncodeToString(sum[:])}
+
+	resp, ok := f.store.Reserve(call.key, f.Window)
+	if !ok {
+		switch {
+		case resp == nil:
+			r.Err = &JsonRpcError{Code: ErrCodeIdempotencyConflict,
+				Message: fmt.Sprintf("barrister: %s: a request with this idempotency key is in progress", r.Method)}
+		case resp.ParamsHash != call.paramsHash:
+			r.Err = &JsonRpcError{Code: ErrCodeIdempotencyConflict,
+				Message: fmt.Sprintf("barrister: %s: idempotency key was used with different params", r.Method)}
+		case resp.Err != nil:
+			r.Err = resp.Err
+		default:
+			r.Result = resp.Result
+		}
+		return false
+	}
+
+	r.Context = context.WithValue(r.Context, idempotentCallKey{}, call)
+	if r.Headers.call != nil {
+		// release the key if the call does not complete, e.g. if it panics
+		// or a later Filter terminates it
+		r.Headers.call.onDone = append(r.Headers.call.onDone, func() {
+			if !call.completed {
+				f.store.Release(call.key)
+			}
+		})
+	}
+	return true
+}
+
+// PostInvoke stores the response of the call
+func (f *IdempotencyFilter) PostInvoke(r *RequestResponse) bool {
+	call, ok := r.Context.Value(idempotentCallKey{}).(*idempotentCall)
+	if !ok {
+		return true
+	}
+
+	resp := &IdempotentResponse{ParamsHash: call.paramsHash}
+	if r.Err != nil {
+		resp.Err = toJsonRpcError(r.Method, r.Err)
+		switch resp.Err.Code {
+		case -32603, ErrCodeTimeout, ErrCodeShuttingDown:
+			return true
+		}
+	} else {
+		b, err := json.Marshal(r.Result)
+		if err != nil {
+			return true
+		}
+		resp.Result = b
+	}
+
+	f.store.Complete(call.key, resp, f.Window)
+	call.completed = true
+	return true
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore that holds responses in memory.
+// Responses are not shared between processes, so clients must send retries
+// to the same server, e.g. with BalanceOptions.HashHeader set to
+// IdempotencyKeyHeader.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+type idempotencyEntry struct {
+	resp    *IdempotentResponse
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore creates an empty MemoryIdempotencyStore
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: map[string]*idempotencyEntry{}, lastSweep: time.Now()}
+}
+
+// Reserve implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Reserve(key string, ttl time.Duration) (*IdempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	e, ok := s.entries[key]
+	if ok && now.Before(e.expires) {
+		return e.resp, false
+	}
+	s.entries[key] = &idempotencyEntry{expires: now.Add(ttl)}
+	return nil, true
+}
+
+// Complete implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Complete(key string, resp *IdempotentResponse, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = &idempotencyEntry{resp: resp, expires: time.Now().Add(ttl)}
+}
+
+// Release implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if ok && e.resp == nil {
+		delete(s.entries, key)
+	}
+}
+
+// NewIdempotencyKey returns a new random idempotency key
+func NewIdempotencyKey() string {
+	return randHex(16)
+}
+
+// WithIdempotencyKey returns a copy of ctx that sends key as the idempotency
+// key of calls made with it.  Use the same key when repeating a call that
+// may have already been made, e.g. after a restart.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return setRequestHeader(ctx, IdempotencyKeyHeader, key)
+}
+
+// IdempotencyKeys returns an Interceptor that attaches a new idempotency key
+// to each call of a method for which attach returns true, unless the call's
+// context already has one.  If attach is nil, keys are attached to all calls.
+//
+// The key is attached once per call, so every retry of the call sends the
+// same key, and RemoteClient.Retry retries calls with a key even if their
+// method is not idempotent.
+//
+//     client.Retry = &barrister.RetryPolicy{MaxAttempts: 3}
+//     client.AddInterceptor(barrister.IdempotencyKeys(func(method string) bool {
+//         return !idl.IsIdempotent(method)
+//     }))
+//
+func IdempotencyKeys(attach func(method string) bool) Interceptor {
+	return idempotencyKeyInterceptor{attach}
+}
+
+// idempotencyKeyInterceptor is returned by IdempotencyKeys
+type idempotencyKeyInterceptor struct {
+	attach func(method string) bool
+}
+
+func (i idempotencyKeyInterceptor) PreCall(c *ClientCall) bool {
+	if RequestHeaderFromContext(c.Context).Get(IdempotencyKeyHeader) != "" {
+		return true
+	}
+	if i.attach == nil || i.attach(c.Method) {
+		c.Context = WithIdempotencyKey(c.Context, NewIdempotencyKey())
+	}
+	return true
+}
+
+func (i idempotencyKeyInterceptor) PostCall(c *ClientCall) bool {
+	return true
+}
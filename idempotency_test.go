@@ -0,0 +1,177 @@
+package barrister
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lostResponseTransport sends each request, but reports the first n as failed
+type lostResponseTransport struct {
+	n     int32
+	sent  int32
+	trans Transport
+}
+
+func (l *lostResponseTransport) Send(in []byte) ([]byte, error) {
+	out, err := l.trans.Send(in)
+	if atomic.AddInt32(&l.sent, 1) <= l.n {
+		return nil, fmt.Errorf("connection reset")
+	}
+	return out, err
+}
+
+func newIdempotentServer() (Server, *int32) {
+	// Counter.total is not @idempotent for these tests
+	idl := MustParseIdlJson([]byte(strings.Replace(streamIdlJson,
+		`"comment": "@idempotent"`, `"comment": ""`, 1)))
+	calls := new(int32)
+	svr := NewJSONServer(idl, true)
+	svr.AddHandler("Counter", countingCounter{calls: calls})
+	svr.AddFilter(NewIdempotencyFilter(idl, NewMemoryIdempotencyStore()))
+	return svr, calls
+}
+
+func keyHeaders(key string) Headers {
+	h := newHeaders()
+	h.Request[IdempotencyKeyHeader] = []string{key}
+	return h
+}
+
+func TestIdempotencyFilter(t *testing.T) {
+	svr, calls := newIdempotentServer()
+
+	for i := 0; i < 2; i++ {
+		res, err := svr.Call(keyHeaders("k1"), "Counter.total", 4)
+		if err != nil || fmt.Sprint(res) != "6" {
+			t.Fatalf("call %d: %v %v", i, res, err)
+		}
+	}
+	if *calls != 1 {
+		t.Errorf("handler called %d times for one key", *calls)
+	}
+
+	_, err := svr.Call(keyHeaders("k1"), "Counter.total", 5)
+	expectErrCode(t, err, ErrCodeIdempotencyConflict)
+
+	// calls without a key are not affected
+	svr.Call(newHeaders(), "Counter.total", 4)
+	svr.Call(newHeaders(), "Counter.total", 4)
+	if *calls != 3 {
+		t.Errorf("calls without key deduplicated: %d calls", *calls)
+	}
+
+	// failed calls release the key so they may be retried
+	for i := 0; i < 2; i++ {
+		_, err = svr.Call(keyHeaders("k2"), "Counter.total", -1)
+		expectErrCode(t, err, -32603)
+	}
+	if *calls != 5 {
+		t.Errorf("failed call was not repeated: %d calls", *calls)
+	}
+}
+
+func TestIdempotencyFilterTimeout(t *testing.T) {
+	idl := MustParseIdlJson([]byte(strings.Replace(streamIdlJson,
+		`"comment": "@idempotent"`, `"comment": ""`, 1)))
+	release := make(chan struct{})
+	svr := NewJSONServer(idl, true)
+	svr.AddHandler("Counter", stubbornCounter{release: release})
+	svr.AddFilter(NewIdempotencyFilter(idl, NewMemoryIdempotencyStore()))
+	svr.SetTimeout(10 * time.Millisecond)
+
+	_, err := svr.Call(keyHeaders("k1"), "Counter.total", 4)
+	expectErrCode(t, err, ErrCodeTimeout)
+
+	// the abandoned handler still holds the key
+	_, err = svr.Call(keyHeaders("k1"), "Counter.total", 4)
+	expectErrCode(t, err, ErrCodeIdempotencyConflict)
+
+	// once it returns, the call may be retried as the timeout was not stored
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	res, err := svr.Call(keyHeaders("k1"), "Counter.total", 4)
+	for e, ok := err.(*JsonRpcError); ok && e.Code == ErrCodeIdempotencyConflict && time.Now().Before(deadline); e, ok = err.(*JsonRpcError) {
+		time.Sleep(time.Millisecond)
+		res, err = svr.Call(keyHeaders("k1"), "Counter.total", 4)
+	}
+	if err != nil || res != int64(4) {
+		t.Errorf("retry after timeout: %v %v", res, err)
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	s := NewMemoryIdempotencyStore()
+	if _, ok := s.Reserve("a", time.Minute); !ok {
+		t.Fatal("new key not reserved")
+	}
+	if resp, ok := s.Reserve("a", time.Minute); ok || resp != nil {
+		t.Errorf("in progress key reserved twice: %v %v", resp, ok)
+	}
+
+	s.Complete("a", &IdempotentResponse{Result: []byte("1")}, time.Minute)
+	s.Release("a")
+	if resp, ok := s.Reserve("a", time.Minute); ok || string(resp.Result) != "1" {
+		t.Errorf("stored response not returned: %v %v", resp, ok)
+	}
+
+	s.Reserve("b", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := s.Reserve("b", time.Minute); !ok {
+		t.Errorf("expired key not reserved")
+	}
+}
+
+func TestIdempotencyKeysRetry(t *testing.T) {
+	svr, calls := newIdempotentServer()
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	// the first response is lost after the server handled the call
+	trans := &lostResponseTransport{n: 1, trans: &HttpTransport{Url: ts.URL}}
+	client := &RemoteClient{Trans: trans, Ser: &JsonSerializer{},
+		Retry: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}}
+	client.AddInterceptor(IdempotencyKeys(nil))
+
+	res, err := client.Call("Counter.total", 4)
+	if err != nil || res != 6.0 || trans.sent != 2 {
+		t.Errorf("Call returned %v %v after %d attempts", res, err, trans.sent)
+	}
+	if *calls != 1 {
+		t.Errorf("handler called %d times for a retried call", *calls)
+	}
+
+	// calls without a key are not retried
+	client.Interceptors = nil
+	trans.n, trans.sent = 1, 0
+	_, err = client.Call("Counter.total", 4)
+	if err == nil || trans.sent != 1 {
+		t.Errorf("Call returned %v after %d attempts", err, trans.sent)
+	}
+}
+
+func TestIdempotencyKeyOverConn(t *testing.T) {
+	svr, calls := newIdempotentServer()
+	serverConn, clientConn := net.Pipe()
+	go svr.ServeConn(serverConn, newHeaders())
+
+	trans := NewConnTransport(clientConn)
+	defer trans.Close()
+	client := NewRemoteClient(trans, true)
+
+	ctx := WithIdempotencyKey(context.Background(), "k1")
+	for i := 0; i < 2; i++ {
+		_, err := CallContext(ctx, client, "Counter.total", 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if *calls != 1 {
+		t.Errorf("handler called %d times for one key", *calls)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/coopernurse/barrister-go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	var outdir string
+	var title string
+	var format string
+	var quiet bool
+	var fromstdin bool
+
+	flag.StringVar(&outdir, "d", ".", "Directory to write documentation to")
+	flag.StringVar(&title, "t", "", "Title of the documentation.  Defaults to the JSON file name")
+	flag.StringVar(&format, "f", "html", "Output format: html, markdown or both")
+	flag.BoolVar(&quiet, "q", false, "Enable quiet mode (no output)")
+	flag.BoolVar(&fromstdin, "i", false, "Read IDL JSON from STDIN")
+	flag.Parse()
+
+	if (!fromstdin && flag.NArg() != 1) || (format != "html" && format != "markdown" && format != "both") {
+		fmt.Fprintf(os.Stderr, "Usage: idl2doc jsonfile\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	jsonFile := flag.Arg(0)
+	baseName := filepath.Base(jsonFile)
+	pos := strings.LastIndex(baseName, ".")
+	if pos > -1 {
+		baseName = baseName[0:pos]
+	}
+	if fromstdin {
+		baseName = "idl"
+	}
+	if title == "" {
+		title = baseName
+	}
+
+	if !quiet {
+		if fromstdin {
+			fmt.Println("Loading IDL from STDIN")
+		} else {
+			fmt.Println("Loading IDL from:", jsonFile)
+		}
+	}
+
+	idl, err := parseIdl(fromstdin, jsonFile)
+	if err != nil {
+		from := jsonFile
+		if fromstdin {
+			from = "STDIN"
+		}
+		fmt.Fprintf(os.Stderr, "Error loading IDL from %s: %s\n", from, err)
+		os.Exit(1)
+	}
+
+	err = os.MkdirAll(outdir, 0755)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating dir %s: %s\n", outdir, err)
+		os.Exit(1)
+	}
+
+	if format == "html" || format == "both" {
+		for name, content := range idl.GenerateHTML(title) {
+			writeFile(quiet, filepath.Join(outdir, name), content)
+		}
+	}
+	if format == "markdown" || format == "both" {
+		writeFile(quiet, filepath.Join(outdir, baseName+".md"), idl.GenerateMarkdown(title))
+	}
+}
+
+func writeFile(quiet bool, outfile string, content []byte) {
+	if !quiet {
+		fmt.Println("Generating", outfile)
+	}
+
+	err := ioutil.WriteFile(outfile, content, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing file %s: %s\n", outfile, err)
+		os.Exit(1)
+	}
+}
+
+func parseIdl(fromstdin bool, jsonFile string) (*barrister.Idl, error) {
+	if fromstdin {
+		jsonData, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		return barrister.ParseIdlJson(jsonData)
+	}
+
+	return barrister.ParseIdlJsonFile(jsonFile)
+}
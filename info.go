@@ -0,0 +1,310 @@
+package barrister
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// InfoInterface is the reserved interface of the introspection methods
+// enabled by Server.EnableInfo:
+//
+//     barrister-info.server  returns ServerInfo
+//     barrister-info.stats   returns []MethodStats
+//
+// Unlike barrister-idl, these calls pass through the Server's Filters, so
+// they can be guarded like IDL methods.  For example, with an AuthzFilter:
+//
+//     authz.Set(barrister.InfoInterface, barrister.Policy{Roles: []string{"ops"}})
+//
+// An AuthzFilter denies them if no Policy applies, unless AllowUnprotected
+// is set.
+const InfoInterface = "barrister-info"
+
+// infoIdlJson describes the InfoInterface methods and types.  InfoClient,
+// ServerInfo, InterfaceInfo and MethodStats can't be generated into this
+// package, so a test checks that they match it.
+const infoIdlJson = `[{
+    "type": "interface", "name": "barrister-info", "comment": "",
+    "functions": [{
+        "name": "server", "comment": "", "params": [],
+        "returns": {"name": "", "type": "ServerInfo", "optional": false, "is_array": false, "comment": ""}
+    }, {
+        "name": "stats", "comment": "", "params": [],
+        "returns": {"name": "", "type": "MethodStats", "optional": false, "is_array": true, "comment": ""}
+    }]
+}, {
+    "type": "struct", "name": "ServerInfo", "comment": "", "extends": "",
+    "fields": [
+        {"name": "version", "type": "string", "optional": false, "is_array": false, "comment": ""},
+        {"name": "started", "type": "int", "optional": false, "is_array": false, "comment": ""},
+        {"name": "uptimeMs", "type": "int", "optional": false, "is_array": false, "comment": ""},
+        {"name": "inFlight", "type": "int", "optional": false, "is_array": false, "comment": ""},
+        {"name": "interfaces", "type": "InterfaceInfo", "optional": false, "is_array": true, "comment": ""},
+        {"name": "filters", "type": "string", "optional": false, "is_array": true, "comment": ""}
+    ]
+}, {
+    "type": "struct", "name": "InterfaceInfo", "comment": "", "extends": "",
+    "fields": [
+        {"name": "name", "type": "string", "optional": false, "is_array": false, "comment": ""},
+        {"name": "handler", "type": "string", "optional": false, "is_array": false, "comment": ""},
+        {"name": "methods", "type": "string", "optional": false, "is_array": true, "comment": ""}
+    ]
+}, {
+    "type": "struct", "name": "MethodStats", "comment": "", "extends": "",
+    "fields": [
+        {"name": "method", "type": "string", "optional": false, "is_array": false, "comment": ""},
+        {"name": "calls", "type": "int", "optional": false, "is_array": false, "comment": ""},
+        {"name": "errors", "type": "int", "optional": false, "is_array": false, "comment": ""},
+        {"name": "inFlight", "type": "int", "optional": false, "is_array": false, "comment": ""},
+        {"name": "avgLatencyMs", "type": "float", "optional": false, "is_array": false, "comment": ""},
+        {"name": "maxLatencyMs", "type": "float", "optional": false, "is_array": false, "comment": ""}
+    ]
+}]`
+
+var infoIdl = MustParseIdlJson([]byte(infoIdlJson))
+
+// ServerInfo describes a running Server
+type ServerInfo struct {
+	// Version passed to EnableInfo
+	Version string `json:"version"`
+
+	// Time the Server was created, in milliseconds since the epoch
+	Started int64 `json:"started"`
+
+	UptimeMs int64 `json:"uptimeMs"`
+
+	// Number of calls being handled, including the barrister-info call.
+	// See Server.InFlight.
+	InFlight int64 `json:"inFlight"`
+
+	// IDL interfaces, sorted by name
+	Interfaces []InterfaceInfo `json:"interfaces"`
+
+	// Go types of the Filters, in the order they run
+	Filters []string `json:"filters"`
+}
+
+// InterfaceInfo describes an IDL interface and its handler
+type InterfaceInfo struct {
+	Name string `json:"name"`
+
+	// Go type of the handler, or "" if no handler was added
+	Handler string `json:"handler"`
+
+	// Methods of the interface, e.g. "Calculator.add"
+	Methods []string `json:"methods"`
+}
+
+// MethodStats are the call statistics of an IDL method since EnableInfo
+// was called
+type MethodStats struct {
+	Method       string  `json:"method"`
+	Calls        int64   `json:"calls"`
+	Errors       int64   `json:"errors"`
+	InFlight     int64   `json:"inFlight"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	MaxLatencyMs float64 `json:"maxLatencyMs"`
+}
+
+// infoState holds the introspection state of a Server.  It is shared by
+// copies of the Server.
+type infoState struct {
+	started time.Time
+
+	// set by EnableInfo, before the Server handles requests
+	enabled bool
+	version string
+	stats   map[string]*methodStats
+}
+
+type methodStats struct {
+	mu       sync.Mutex
+	calls    int64
+	errors   int64
+	inFlight int64
+	total    time.Duration
+	max      time.Duration
+}
+
+func newInfoState() *infoState {
+	return &infoState{started: time.Now()}
+}
+
+// EnableInfo enables the InfoInterface methods, which report the given
+// version, and starts collecting MethodStats.  They are disabled by default,
+// and fail with code -32601.  EnableInfo must be called before the Server
+// handles requests.
+func (s *Server) EnableInfo(version string) {
+	s.info.enabled = true
+	s.info.version = version
+	s.info.stats = map[string]*methodStats{}
+	for name, fn := range s.idl.methods {
+		if !fn.IsEvent() {
+			s.info.stats[name] = &methodStats{}
+		}
+	}
+}
+
+// track records the start of a call to method.  The returned func records
+// its end, and must be called with the call's error.
+func (i *infoState) track(method string) func(err *error) {
+	st := i.stats[method]
+	if st == nil {
+		return func(err *error) {}
+	}
+
+	start := time.Now()
+	st.mu.Lock()
+	st.inFlight++
+	st.mu.Unlock()
+
+	return func(err *error) {
+		elapsed := time.Since(start)
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		st.inFlight--
+		st.calls++
+		if *err != nil {
+			st.errors++
+		}
+		st.total += elapsed
+		if elapsed > st.max {
+			st.max = elapsed
+		}
+	}
+}
+
+// serverInfo returns the ServerInfo of s
+func (s *Server) serverInfo() ServerInfo {
+	info := ServerInfo{
+		Version:    s.info.version,
+		Started:    s.info.started.UnixNano() / int64(time.Millisecond),
+		UptimeMs:   time.Since(s.info.started).Milliseconds(),
+		InFlight:   int64(s.InFlight()),
+		Interfaces: []InterfaceInfo{},
+		Filters:    []string{},
+	}
+
+	names := []string{}
+	for name := range s.idl.interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		iface := InterfaceInfo{Name: name, Methods: []string{}}
+		if h, ok := s.handlers[name]; ok {
+			iface.Handler = fmt.Sprintf("%T", h)
+		}
+		for _, fn := range s.idl.interfaces[name] {
+			iface.Methods = append(iface.Methods, name+"."+fn.Name)
+		}
+		info.Interfaces = append(info.Interfaces, iface)
+	}
+
+	for _, f := range s.filters {
+		info.Filters = append(info.Filters, fmt.Sprintf("%T", f))
+	}
+	return info
+}
+
+// methodStats returns the MethodStats of each IDL method, sorted by method
+func (s *Server) methodStats() []MethodStats {
+	stats := []MethodStats{}
+	for name, st := range s.info.stats {
+		st.mu.Lock()
+		ms := MethodStats{Method: name, Calls: st.calls, Errors: st.errors, InFlight: st.inFlight,
+			MaxLatencyMs: float64(st.max) / float64(time.Millisecond)}
+		if st.calls > 0 {
+			ms.AvgLatencyMs = float64(st.total) / float64(st.calls) / float64(time.Millisecond)
+		}
+		st.mu.Unlock()
+		stats = append(stats, ms)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Method < stats[j].Method })
+	return stats
+}
+
+// callInfo handles a call to an InfoInterface method, running the Filters
+// as for IDL methods
+func (s *Server) callInfo(headers Headers, method string, params []interface{}) (result interface{}, err error) {
+	if !s.info.enabled {
+		return nil, &JsonRpcError{Code: -32601, Message: fmt.Sprintf("Unsupported method: %s", method)}
+	}
+	if _, ok := infoIdl.methods[method]; !ok {
+		return nil, &JsonRpcError{Code: -32601, Message: fmt.Sprintf("Unsupported method: %s", method)}
+	}
+	if len(params) != 0 {
+		return nil, &JsonRpcError{Code: -32602,
+			Message: fmt.Sprintf("Method %s expects 0 params but was passed %d", method, len(params))}
+	}
+
+	ctx := headers.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	headers.call = &callState{ctx: ctx}
+	defer func() { headers.call.done(err) }()
+
+	rr := &RequestResponse{Headers: headers, Method: method, Params: params, Context: ctx}
+	for _, f := range s.filters {
+		if !f.PreInvoke(rr) {
+			return rr.Result, rr.Err
+		}
+	}
+
+	if method == InfoInterface+".server" {
+		rr.Result = s.serverInfo()
+	} else {
+		rr.Result = s.methodStats()
+	}
+
+	for i := len(s.filters) - 1; i >= 0; i-- {
+		if !s.filters[i].PostInvoke(rr) {
+			break
+		}
+	}
+	return rr.Result, rr.Err
+}
+
+// InfoClient calls the InfoInterface methods of a remote Server
+type InfoClient struct {
+	client Client
+}
+
+// NewInfoClient creates an InfoClient that calls the Server through c
+func NewInfoClient(c Client) InfoClient {
+	return InfoClient{c}
+}
+
+// Server returns the ServerInfo of the Server
+func (p InfoClient) Server() (ServerInfo, error) {
+	res, err := p.call("server", reflect.TypeOf(ServerInfo{}))
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	return res.(ServerInfo), nil
+}
+
+// Stats returns the MethodStats of the Server
+func (p InfoClient) Stats() ([]MethodStats, error) {
+	res, err := p.call("stats", reflect.TypeOf([]MethodStats{}))
+	if err != nil {
+		return nil, err
+	}
+	return res.([]MethodStats), nil
+}
+
+// call calls an InfoInterface function and converts its result to desired
+func (p InfoClient) call(fname string, desired reflect.Type) (interface{}, error) {
+	method := InfoInterface + "." + fname
+	res, err := p.client.Call(method)
+	if err != nil {
+		return nil, err
+	}
+	retType := infoIdl.Method(method).Returns
+	return Convert(infoIdl, &retType, desired, res, "")
+}
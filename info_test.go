@@ -0,0 +1,152 @@
+package barrister
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestInfoDisabled(t *testing.T) {
+	svr := newCounterServer()
+	_, err := svr.Call(newHeaders(), "barrister-info.server")
+	expectErrCode(t, err, -32601)
+}
+
+func TestInfoClient(t *testing.T) {
+	svr := newCounterServer()
+	svr.EnableInfo("1.2.3")
+	svr.AddFilter(NewLimiter())
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	svr.Call(newHeaders(), "Counter.total", 3)
+	svr.Call(newHeaders(), "Counter.total", 4)
+	svr.Call(newHeaders(), "Counter.total", -1)
+
+	info := NewInfoClient(NewRemoteClient(&HttpTransport{Url: ts.URL}, true))
+	server, err := info.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Version != "1.2.3" || server.Started == 0 || server.InFlight != 1 {
+		t.Errorf("unexpected ServerInfo: %+v", server)
+	}
+	if len(server.Interfaces) != 1 || server.Interfaces[0].Name != "Counter" ||
+		server.Interfaces[0].Handler != "barrister.CounterImpl" || len(server.Interfaces[0].Methods) != 3 {
+		t.Errorf("unexpected interfaces: %+v", server.Interfaces)
+	}
+	if len(server.Filters) != 1 || server.Filters[0] != "*barrister.Limiter" {
+		t.Errorf("unexpected filters: %v", server.Filters)
+	}
+
+	stats, err := info.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("expected stats for count and total, got: %+v", stats)
+	}
+	total := stats[1]
+	if total.Method != "Counter.total" || total.Calls != 3 || total.Errors != 1 || total.InFlight != 0 {
+		t.Errorf("unexpected stats: %+v", total)
+	}
+
+	_, err = svr.Call(newHeaders(), "barrister-info.bogus")
+	expectErrCode(t, err, -32601)
+}
+
+func TestInfoGuardedByAuthz(t *testing.T) {
+	svr, _ := newAuthServer(&AuthFilter{AllowAnonymous: true})
+	svr.EnableInfo("1.0")
+	authz := NewAuthzFilter(svr.idl)
+	authz.Set("Counter", Policy{Public: true})
+	svr.AddFilter(authz)
+
+	// no Policy for the info methods
+	_, err := svr.Call(newHeaders(), "barrister-info.server")
+	expectErrCode(t, err, ErrCodeForbidden)
+
+	authz.Set(InfoInterface, Policy{Roles: []string{"ops"}})
+	_, err = svr.Call(newHeaders(), "barrister-info.stats")
+	expectErrCode(t, err, ErrCodeUnauthenticated)
+}
+
+// TestInfoClientMatchesIdl checks the hand written InfoClient and its types
+// against infoIdlJson, as the generator can't write into this package
+func TestInfoClientMatchesIdl(t *testing.T) {
+	goTypes := map[string]reflect.Type{
+		"ServerInfo":    reflect.TypeOf(ServerInfo{}),
+		"InterfaceInfo": reflect.TypeOf(InterfaceInfo{}),
+		"MethodStats":   reflect.TypeOf(MethodStats{}),
+	}
+	kinds := map[string]reflect.Kind{"string": reflect.String, "int": reflect.Int64, "float": reflect.Float64}
+
+	// goType returns the Go type an IDL type should map to
+	goType := func(f Field) (reflect.Kind, string) {
+		if f.IsArray {
+			return reflect.Slice, f.Type
+		}
+		if k, ok := kinds[f.Type]; ok {
+			return k, ""
+		}
+		return reflect.Struct, f.Type
+	}
+	checkType := func(what string, typ reflect.Type, f Field) {
+		kind, name := goType(f)
+		if typ.Kind() != kind {
+			t.Errorf("%s: %v does not match IDL type %s", what, typ, f.Type)
+		}
+		if kind == reflect.Slice {
+			typ = typ.Elem()
+			if _, ok := kinds[f.Type]; ok {
+				name = ""
+				if typ.Kind() != kinds[f.Type] {
+					t.Errorf("%s: %v does not match IDL type []%s", what, typ, f.Type)
+				}
+			}
+		}
+		if name != "" && typ.Name() != name {
+			t.Errorf("%s: %v does not match IDL type %s", what, typ, f.Type)
+		}
+	}
+
+	client := reflect.TypeOf(InfoClient{})
+	funcs := infoIdl.interfaces[InfoInterface]
+	if client.NumMethod() != len(funcs) {
+		t.Errorf("InfoClient has %d methods, IDL has %d", client.NumMethod(), len(funcs))
+	}
+	for _, fn := range funcs {
+		m, ok := client.MethodByName(capitalize(fn.Name))
+		if !ok {
+			t.Errorf("InfoClient has no method for %s", fn.Name)
+			continue
+		}
+		if m.Type.NumIn() != len(fn.Params)+1 || m.Type.NumOut() != 2 || m.Type.Out(1) != typeOfError {
+			t.Errorf("InfoClient.%s has signature %v", m.Name, m.Type)
+			continue
+		}
+		checkType("InfoClient."+m.Name, m.Type.Out(0), fn.Returns)
+	}
+
+	if len(infoIdl.structs) != len(goTypes) {
+		t.Errorf("IDL has %d structs, expected %d", len(infoIdl.structs), len(goTypes))
+	}
+	for name, st := range infoIdl.structs {
+		typ, ok := goTypes[name]
+		if !ok {
+			t.Errorf("no Go type for IDL struct %s", name)
+			continue
+		}
+		if typ.NumField() != len(st.Fields) {
+			t.Errorf("%s has %d fields, IDL has %d", name, typ.NumField(), len(st.Fields))
+		}
+		for _, f := range st.Fields {
+			sf, ok := typ.FieldByName(capitalize(f.Name))
+			if !ok || sf.Tag.Get("json") != f.Name {
+				t.Errorf("%s has no field for %s", name, f.Name)
+				continue
+			}
+			checkType(name+"."+sf.Name, sf.Type, f)
+		}
+	}
+}
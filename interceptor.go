@@ -0,0 +1,90 @@
+package barrister
+
+import (
+	"context"
+)
+
+// ClientCall is passed to each Interceptor registered with a RemoteClient.
+// It is the client side counterpart of RequestResponse.
+type ClientCall struct {
+	// Bounds the call.  Interceptors may replace it, e.g. to add request
+	// headers with WithRequestHeader
+	Context context.Context
+
+	// IDL method being called. e.g. "Calculator.add"
+	Method string
+
+	// Params before they are marshaled.  Interceptors may alter them.
+	Params []interface{}
+
+	// Result and error of the call after the response is unmarshaled
+	Result interface{}
+	Err    error
+}
+
+// Interceptors allow you to intercept calls made by a RemoteClient before the
+// request is marshaled and after the response is unmarshaled, regardless of
+// Transport.  They are useful for the same cross cutting concerns as Filters
+// are on the server: logging, adding auth tokens, measuring latency, etc.
+type Interceptor interface {
+
+	// PreCall is called before the request is marshaled.
+	//
+	// Return value of false terminates the interceptor chain, no request is
+	// sent, and c.Result, c.Err will be returned to the caller.
+	// Return value of true continues interceptor chain execution.
+	//
+	PreCall(c *ClientCall) bool
+
+	// PostCall is called after the response is unmarshaled, or the call
+	// failed, and returns a bool that indicates whether later interceptors
+	// should be called.
+	//
+	// Implementations may alter c.Result and c.Err, which will be returned
+	// to the caller.
+	//
+	PostCall(c *ClientCall) bool
+}
+
+// AddInterceptor registers an Interceptor with the RemoteClient.
+// Interceptor.PreCall is called in the order of registration.
+// Interceptor.PostCall is called in reverse order of registration.
+//
+// Interceptors run once per Call, regardless of how many times a call is
+// retried.  Batch calls, streams and subscriptions are not intercepted.
+//
+func (c *RemoteClient) AddInterceptor(i Interceptor) {
+	c.Interceptors = append(c.Interceptors, i)
+}
+
+// intercept runs the interceptor chain around call
+func (c *RemoteClient) intercept(ctx context.Context, method string, params []interface{},
+	call func(ctx context.Context, method string, params []interface{}) (interface{}, error)) (interface{}, error) {
+
+	if len(c.Interceptors) == 0 {
+		return call(ctx, method, params)
+	}
+
+	cc := &ClientCall{Context: ctx, Method: method, Params: params}
+
+	// run interceptors - PreCall
+	ilen := len(c.Interceptors)
+	for i := 0; i < ilen; i++ {
+		ok := c.Interceptors[i].PreCall(cc)
+		if !ok {
+			return cc.Result, cc.Err
+		}
+	}
+
+	cc.Result, cc.Err = call(cc.Context, cc.Method, cc.Params)
+
+	// run interceptors - PostCall
+	for i := ilen - 1; i >= 0; i-- {
+		ok := c.Interceptors[i].PostCall(cc)
+		if !ok {
+			break
+		}
+	}
+
+	return cc.Result, cc.Err
+}
@@ -0,0 +1,257 @@
+package barrister
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// JWTAuthenticator authenticates requests that carry a JSON Web Token in an
+// "Authorization: Bearer <token>" header.  Tokens are verified locally with
+// Keys, so no call to the issuer is needed.
+//
+// Supported algorithms are HS256, HS384 and HS512 with a []byte secret,
+// RS256, RS384 and RS512 with an *rsa.PublicKey, and ES256, ES384 and ES512
+// with an *ecdsa.PublicKey.  The "exp" and "nbf" claims are checked when
+// present.  Set RequireExp to reject tokens without "exp".
+//
+// The Principal Id is the "sub" claim, and Roles are taken from RolesClaim,
+// which may be a JSON array or a space separated string (as in "scope").
+//
+//     auth := &barrister.JWTAuthenticator{
+//         Keys:     map[string]interface{}{"": []byte(secret)},
+//         Issuer:   "https://auth.example.com",
+//         Audience: "calc-service",
+//     }
+//
+type JWTAuthenticator struct {
+	// Verification keys by "kid" header.  The key "" is used for tokens
+	// without a kid.
+	Keys map[string]interface{}
+
+	// If set, the "iss" claim must equal Issuer
+	Issuer string
+
+	// If set, the "aud" claim must equal Audience, or be an array with an
+	// element equal to Audience
+	Audience string
+
+	// Clock skew allowed when checking "exp" and "nbf"
+	Leeway time.Duration
+
+	// If true, tokens without an "exp" claim are rejected
+	RequireExp bool
+
+	// Claim that holds the roles of the Principal.  Defaults to "roles".
+	RolesClaim string
+}
+
+// Authenticate implements Authenticator
+func (a *JWTAuthenticator) Authenticate(headers *Headers) (*Principal, error) {
+	token := bearerToken(headers)
+	if token == "" {
+		return nil, nil
+	}
+	return a.Verify(token)
+}
+
+// Verify checks the signature and claims of token, and returns its Principal
+func (a *JWTAuthenticator) Verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := a.Keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown JWT key id: %s", header.Kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWT signature")
+	}
+	err = verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+	err = a.checkClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	rolesClaim := a.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	return &Principal{Id: sub, Scheme: "bearer", Roles: jwtRoles(claims[rolesClaim]), Claims: claims}, nil
+}
+
+func (a *JWTAuthenticator) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok {
+		if now.After(time.Unix(int64(exp), 0).Add(a.Leeway)) {
+			return fmt.Errorf("JWT expired")
+		}
+	} else if a.RequireExp {
+		return fmt.Errorf("JWT has no exp claim")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Before(time.Unix(int64(nbf), 0).Add(-a.Leeway)) {
+			return fmt.Errorf("JWT not yet valid")
+		}
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return fmt.Errorf("JWT issuer not accepted: %v", claims["iss"])
+	}
+	if a.Audience != "" && !jwtAudience(claims["aud"], a.Audience) {
+		return fmt.Errorf("JWT audience not accepted: %v", claims["aud"])
+	}
+	return nil
+}
+
+// decodeJWTPart decodes a base64url encoded JSON segment of a JWT
+func decodeJWTPart(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("malformed JWT")
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("malformed JWT")
+	}
+	return nil
+}
+
+// jwtHash returns the hash of the JWT algorithm's size: 256, 384 or 512
+func jwtHash(alg string) (crypto.Hash, func() hash.Hash, bool) {
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, sha256.New, true
+	case "384":
+		return crypto.SHA384, sha512.New384, true
+	case "512":
+		return crypto.SHA512, sha512.New, true
+	}
+	return 0, nil, false
+}
+
+// verifyJWTSignature checks sig over signed.  The key type must match the
+// algorithm, so a public key can not be used as an HMAC secret.
+func verifyJWTSignature(alg string, key interface{}, signed, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported JWT algorithm: %s", alg)
+	}
+	h, newHash, ok := jwtHash(alg)
+	if !ok {
+		return fmt.Errorf("unsupported JWT algorithm: %s", alg)
+	}
+
+	switch k := key.(type) {
+	case []byte:
+		if alg[:2] != "HS" {
+			break
+		}
+		mac := hmac.New(newHash, k)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return fmt.Errorf("invalid JWT signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			break
+		}
+		digest := newHash()
+		digest.Write(signed)
+		if rsa.VerifyPKCS1v15(k, h, digest.Sum(nil), sig) != nil {
+			return fmt.Errorf("invalid JWT signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid JWT signature")
+		}
+		digest := newHash()
+		digest.Write(signed)
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest.Sum(nil), r, s) {
+			return fmt.Errorf("invalid JWT signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("JWT algorithm %s does not match key type %T", alg, key)
+}
+
+// jwtRoles returns a claim that is a JSON array or space separated string
+// as a []string
+func jwtRoles(claim interface{}) []string {
+	switch t := claim.(type) {
+	case string:
+		return strings.Fields(t)
+	case []interface{}:
+		roles := make([]string, 0, len(t))
+		for _, r := range t {
+			if s, ok := r.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+		return roles
+	}
+	return nil
+}
+
+// jwtAudience returns true if the "aud" claim is aud, or is an array that
+// contains aud.  Unlike roles, a string claim is not split on spaces.
+func jwtAudience(claim interface{}, aud string) bool {
+	switch t := claim.(type) {
+	case string:
+		return t == aud
+	case []interface{}:
+		for _, v := range t {
+			if v == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
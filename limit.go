@@ -0,0 +1,287 @@
+package barrister
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrCodeLimitExceeded is the JsonRpcError code returned by Limiter for
+// rejected requests.  The error Data is a map with these keys:
+//
+//     limit       - "rate", "caller_rate" or "in_flight"
+//     retry_after - seconds until a request may be allowed.  Not set for "in_flight".
+//
+const ErrCodeLimitExceeded = -32003
+
+// Limit bounds the requests to an IDL method.  Zero values are unlimited.
+type Limit struct {
+	// Requests per second allowed across all callers
+	Rate float64
+
+	// Requests allowed in a burst across all callers.  Defaults to Rate,
+	// rounded up.
+	Burst int
+
+	// Requests per second allowed for each caller (see Limiter.Identity)
+	CallerRate float64
+
+	// Requests allowed in a burst for each caller.  Defaults to CallerRate,
+	// rounded up.
+	CallerBurst int
+
+	// Requests that may be in progress at once
+	MaxInFlight int
+}
+
+// Limiter is a Filter that rejects requests that exceed the Limit of their
+// IDL method with code ErrCodeLimitExceeded.  Rates are enforced with token
+// buckets, per method and per caller of each method.
+//
+// Limits may be set for a method ("Calculator.add"), an interface
+// ("Calculator"), or all methods ("*").  The most specific Limit applies,
+// and each method has its own buckets and in-flight count.  Limits may be
+// changed with SetLimit while the Server is running.
+//
+//     limiter := barrister.NewLimiter()
+//     limiter.SetLimit("*", barrister.Limit{CallerRate: 10, CallerBurst: 20})
+//     limiter.SetLimit("Reports.generate", barrister.Limit{MaxInFlight: 4})
+//     svr.AddFilter(barrister.NewAuthFilter(jwtAuth))
+//     svr.AddFilter(limiter)
+//
+type Limiter struct {
+	// Returns the identity of the caller, for per caller rates.  By default
+	// the Principal Id is used, or the host of Headers.RemoteAddr if the
+	// request was not authenticated.  Set before the Server starts.
+	Identity func(r *RequestResponse) string
+
+	mu       sync.Mutex
+	limits   map[string]Limit
+	buckets  map[limitKey]*tokenBucket
+	inFlight map[string]int
+}
+
+// limitKey identifies the bucket of a method, or of a caller of a method
+type limitKey struct {
+	method    string
+	perCaller bool
+	caller    string
+}
+
+// NewLimiter creates a Limiter without any Limits
+func NewLimiter() *Limiter {
+	return &Limiter{
+		Identity: defaultIdentity,
+		limits:   map[string]Limit{},
+		buckets:  map[limitKey]*tokenBucket{},
+		inFlight: map[string]int{},
+	}
+}
+
+// defaultIdentity is the default Limiter.Identity
+func defaultIdentity(r *RequestResponse) string {
+	if p := PrincipalFromContext(r.Context); p != nil {
+		return p.Id
+	}
+	host, _, err := net.SplitHostPort(r.Headers.RemoteAddr)
+	if err != nil {
+		return r.Headers.RemoteAddr
+	}
+	return host
+}
+
+// SetLimit sets the Limit of a method, an interface, or "*".  The rate
+// buckets of methods that the Limit applies to are reset.  In-flight
+// requests are not affected.
+func (l *Limiter) SetLimit(name string, limit Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits[name] = limit
+	l.resetBuckets(name)
+}
+
+// RemoveLimit removes the Limit set for name.  The rate buckets of methods
+// that the Limit applied to are reset.
+func (l *Limiter) RemoveLimit(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.resetBuckets(name)
+	delete(l.limits, name)
+}
+
+// resetBuckets discards the buckets of methods whose Limit is the one set
+// for name.  Callers must hold l.mu.
+func (l *Limiter) resetBuckets(name string) {
+	for key := range l.buckets {
+		if limitName, _ := l.limitName(key.method); limitName == name {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Limits returns a copy of the Limits, keyed by the names they were set for
+func (l *Limiter) Limits() map[string]Limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limits := make(map[string]Limit, len(l.limits))
+	for k, v := range l.limits {
+		limits[k] = v
+	}
+	return limits
+}
+
+// limit returns the most specific Limit for method.  Callers must hold l.mu.
+func (l *Limiter) limit(method string) (Limit, bool) {
+	name, ok := l.limitName(method)
+	return l.limits[name], ok
+}
+
+// limitName returns the name the most specific Limit for method was set
+// for.  Callers must hold l.mu.
+func (l *Limiter) limitName(method string) (string, bool) {
+	if _, ok := l.limits[method]; ok {
+		return method, true
+	}
+	iface, _ := parseMethod(method)
+	if _, ok := l.limits[iface]; ok {
+		return iface, true
+	}
+	_, ok := l.limits["*"]
+	return "*", ok
+}
+
+// PreInvoke rejects the request if it exceeds the Limit of its method
+func (l *Limiter) PreInvoke(r *RequestResponse) bool {
+	l.mu.Lock()
+	limit, ok := l.limit(r.Method)
+
+	caller := ""
+	if ok && limit.CallerRate > 0 {
+		// Identity may be slow, and is not guarded by l.mu.  The Limit is
+		// read again, as it may have been changed meanwhile.
+		l.mu.Unlock()
+		caller = l.Identity(r)
+		l.mu.Lock()
+		limit, ok = l.limit(r.Method)
+	}
+	if !ok {
+		l.mu.Unlock()
+		return true
+	}
+
+	now := time.Now()
+	var kind string
+	var wait time.Duration
+	methodKey, callerKey := limitKey{r.Method, false, ""}, limitKey{r.Method, true, caller}
+	if limit.MaxInFlight > 0 && l.inFlight[r.Method] >= limit.MaxInFlight {
+		kind = "in_flight"
+	} else if limit.Rate > 0 && !l.bucket(methodKey, limit.Rate, limit.Burst, now).available() {
+		kind, wait = "rate", l.buckets[methodKey].wait()
+	} else if limit.CallerRate > 0 && !l.bucket(callerKey, limit.CallerRate, limit.CallerBurst, now).available() {
+		kind, wait = "caller_rate", l.buckets[callerKey].wait()
+	}
+
+	if kind == "" {
+		// only take tokens once the request is allowed by every limit
+		if limit.Rate > 0 {
+			l.buckets[methodKey].tokens--
+		}
+		if limit.CallerRate > 0 {
+			l.buckets[callerKey].tokens--
+		}
+		if limit.MaxInFlight > 0 {
+			l.inFlight[r.Method]++
+			if r.Headers.call != nil {
+				r.Headers.call.onDone = append(r.Headers.call.onDone, func() { l.release(r.Method) })
+			}
+		}
+	}
+	l.mu.Unlock()
+
+	if kind == "" {
+		return true
+	}
+
+	data := map[string]interface{}{"limit": kind}
+	if kind != "in_flight" {
+		data["retry_after"] = wait.Seconds()
+	}
+	r.Err = &JsonRpcError{Code: ErrCodeLimitExceeded, Data: data,
+		Message: fmt.Sprintf("barrister: %s limit exceeded for %s", kind, r.Method)}
+	return false
+}
+
+// PostInvoke does nothing.  In-flight requests are released when the call
+// returns, even if a later Filter terminates it.
+func (l *Limiter) PostInvoke(r *RequestResponse) bool {
+	return true
+}
+
+func (l *Limiter) release(method string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight[method]--
+	if l.inFlight[method] <= 0 {
+		delete(l.inFlight, method)
+	}
+}
+
+// bucket returns the refilled bucket for key, creating it if needed.
+// Callers must hold l.mu.
+func (l *Limiter) bucket(key limitKey, rate float64, burst int, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxLimitBuckets {
+			l.sweep(now)
+		}
+		if burst <= 0 {
+			burst = int(math.Ceil(rate))
+		}
+		b = &tokenBucket{tokens: float64(burst), burst: float64(burst), rate: rate, last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	return b
+}
+
+// maxLimitBuckets is the number of buckets above which full caller buckets
+// are discarded, so that memory use is not proportional to the number of callers
+const maxLimitBuckets = 10000
+
+// sweep discards caller buckets that have refilled, as a new bucket is
+// equivalent.  Callers must hold l.mu.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if key.perCaller {
+			b.refill(now)
+			if b.tokens >= b.burst {
+				delete(l.buckets, key)
+			}
+		}
+	}
+}
+
+// tokenBucket allows burst requests at once, and is refilled at rate tokens
+// per second
+type tokenBucket struct {
+	tokens float64
+	burst  float64
+	rate   float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func (b *tokenBucket) available() bool {
+	return b.tokens >= 1
+}
+
+// wait returns the time until the bucket has a token
+func (b *tokenBucket) wait() time.Duration {
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
@@ -0,0 +1,154 @@
+package barrister
+
+import (
+	"testing"
+)
+
+func limitErr(t *testing.T, err error) map[string]interface{} {
+	e, ok := err.(*JsonRpcError)
+	if !ok || e.Code != ErrCodeLimitExceeded {
+		t.Fatalf("expected ErrCodeLimitExceeded, got: %v", err)
+	}
+	return e.Data.(map[string]interface{})
+}
+
+func addrHeaders(addr string) Headers {
+	h := newHeaders()
+	h.RemoteAddr = addr
+	return h
+}
+
+func TestLimiterRate(t *testing.T) {
+	limiter := NewLimiter()
+	limiter.SetLimit("Counter", Limit{Rate: 1, Burst: 2})
+	svr := newCounterServer()
+	svr.AddFilter(limiter)
+
+	for i := 0; i < 2; i++ {
+		_, err := svr.Call(newHeaders(), "Counter.total", 3)
+		if err != nil {
+			t.Fatalf("call %d rejected: %v", i, err)
+		}
+	}
+	_, err := svr.Call(newHeaders(), "Counter.total", 3)
+	data := limitErr(t, err)
+	if data["limit"] != "rate" || data["retry_after"].(float64) <= 0 {
+		t.Errorf("unexpected error data: %v", data)
+	}
+
+	// limits apply per method
+	_, err = svr.Call(newHeaders(), "Counter.count", 3)
+	if err != nil {
+		t.Errorf("other method rejected: %v", err)
+	}
+
+	// limits can be changed at runtime
+	limiter.SetLimit("Counter.total", Limit{Rate: 100})
+	_, err = svr.Call(newHeaders(), "Counter.total", 3)
+	if err != nil {
+		t.Errorf("call rejected after SetLimit: %v", err)
+	}
+	limiter.SetLimit("Counter.total", Limit{Rate: 0.001, Burst: 1})
+	svr.Call(newHeaders(), "Counter.total", 3)
+	limiter.RemoveLimit("Counter.total")
+	limiter.RemoveLimit("Counter")
+	_, err = svr.Call(newHeaders(), "Counter.total", 3)
+	if err != nil || len(limiter.Limits()) != 0 {
+		t.Errorf("call rejected after RemoveLimit: %v %v", err, limiter.Limits())
+	}
+}
+
+func TestLimiterSetLimitKeepsOtherBuckets(t *testing.T) {
+	limiter := NewLimiter()
+	limiter.SetLimit("Counter", Limit{Rate: 0.001, Burst: 1})
+	svr := newCounterServer()
+	svr.AddFilter(limiter)
+
+	resultOk(svr.Call(newHeaders(), "Counter.total", 3))
+	limiter.SetLimit("Other", Limit{Rate: 100})
+	limiter.SetLimit("Counter.count", Limit{Rate: 100})
+	limiter.RemoveLimit("Other")
+	_, err := svr.Call(newHeaders(), "Counter.total", 3)
+	if data := limitErr(t, err); data["limit"] != "rate" {
+		t.Errorf("unexpected error data: %v", data)
+	}
+}
+
+func TestLimiterRereadsLimitAfterIdentity(t *testing.T) {
+	limiter := NewLimiter()
+	limiter.SetLimit("*", Limit{CallerRate: 100})
+	limiter.Identity = func(r *RequestResponse) string {
+		// replaced while the caller is being resolved
+		limiter.SetLimit("*", Limit{Rate: 0.001, Burst: 1})
+		return "a"
+	}
+	svr := newCounterServer()
+	svr.AddFilter(limiter)
+
+	resultOk(svr.Call(newHeaders(), "Counter.total", 3))
+	_, err := svr.Call(newHeaders(), "Counter.total", 3)
+	if data := limitErr(t, err); data["limit"] != "rate" {
+		t.Errorf("unexpected error data: %v", data)
+	}
+}
+
+func TestLimiterCallerRate(t *testing.T) {
+	limiter := NewLimiter()
+	limiter.SetLimit("*", Limit{CallerRate: 0.001, CallerBurst: 1})
+	svr := newCounterServer()
+	svr.AddFilter(limiter)
+
+	_, err := svr.Call(addrHeaders("10.0.0.1:5000"), "Counter.total", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svr.Call(addrHeaders("10.0.0.1:5001"), "Counter.total", 3)
+	if data := limitErr(t, err); data["limit"] != "caller_rate" {
+		t.Errorf("unexpected error data: %v", data)
+	}
+	_, err = svr.Call(addrHeaders("10.0.0.2:5000"), "Counter.total", 3)
+	if err != nil {
+		t.Errorf("other caller rejected: %v", err)
+	}
+}
+
+func TestLimiterMaxInFlight(t *testing.T) {
+	limiter := NewLimiter()
+	limiter.SetLimit("Counter.count", Limit{MaxInFlight: 1})
+	svr := newCounterServer()
+	svr.AddFilter(limiter)
+
+	started, release := make(chan bool), make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- svr.CallStream(newHeaders(), "Counter.count", func(item interface{}) error {
+			if item == int64(0) {
+				started <- true
+				<-release
+			}
+			return nil
+		}, 2)
+	}()
+	<-started
+
+	_, err := svr.Call(newHeaders(), "Counter.count", 2)
+	if data := limitErr(t, err); data["limit"] != "in_flight" || data["retry_after"] != nil {
+		t.Errorf("unexpected error data: %v", data)
+	}
+
+	release <- true
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	_, err = svr.Call(newHeaders(), "Counter.count", 2)
+	if err != nil {
+		t.Errorf("call rejected after in-flight call completed: %v", err)
+	}
+
+	// in-flight calls are released if a later filter terminates the call
+	svr.AddFilter(NewAuthzFilter(svr.idl))
+	for i := 0; i < 2; i++ {
+		_, err = svr.Call(newHeaders(), "Counter.count", 2)
+		expectErrCode(t, err, ErrCodeForbidden)
+	}
+}
@@ -0,0 +1,227 @@
+package barrister
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// LogSink receives one record per request logged by a LogFilter.
+// *slog.Logger implements LogSink.
+type LogSink interface {
+	LogAttrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr)
+}
+
+// LogOptions configure a LogFilter
+type LogOptions struct {
+	// Message of each record.  Defaults to "barrister request"
+	Message string
+
+	// Request headers to include in each record
+	Headers []string
+
+	// If true, the request params are included in each record
+	LogParams bool
+
+	// IDL param and struct field names whose values are replaced with
+	// "[REDACTED]" when params are logged.  e.g. "password"
+	Redact []string
+}
+
+// LogFilter is a Filter that writes a structured record for each request
+// to a LogSink.  Each record has these attributes:
+//
+//     method       - IDL method. e.g. "Calculator.add"
+//     duration     - time from PreInvoke to PostInvoke
+//     params_bytes - size of the params encoded as JSON
+//     error_code   - JsonRpcError.Code, if the request failed
+//     error        - error message, if the request failed
+//     headers      - group of the headers listed in LogOptions.Headers
+//     params       - params keyed by IDL param name, if LogOptions.LogParams is set
+//
+// Successful requests are logged at slog.LevelInfo, internal errors (-32603
+// and -32000) at slog.LevelError, and other errors at slog.LevelWarn.
+//
+// The record is written when the call returns, so calls whose handler
+// panics, whose params are invalid, or that a later Filter rejects are
+// logged with their error.  Register LogFilter before other Filters, as
+// calls rejected by an earlier Filter never reach it.
+type LogFilter struct {
+	idl    *Idl
+	sink   LogSink
+	opts   LogOptions
+	redact map[string]bool
+}
+
+type logStartKey struct{}
+
+// NewLogFilter creates a LogFilter that writes to sink.  idl is used to name
+// the logged params.  NewLogFilter panics if a name in LogOptions.Redact is
+// not a param or struct field in the IDL, as that is probably a typo that
+// would leak the value into logs.
+//
+// Example:
+//
+//     logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+//     svr.AddFilter(barrister.NewLogFilter(idl, logger, barrister.LogOptions{
+//         Headers: []string{"X-Request-Id"},
+//         Redact:  []string{"password"},
+//     }))
+//
+func NewLogFilter(idl *Idl, sink LogSink, opts LogOptions) *LogFilter {
+	if opts.Message == "" {
+		opts.Message = "barrister request"
+	}
+	fields := map[string]bool{}
+	for _, fn := range idl.methods {
+		for _, p := range fn.Params {
+			fields[strings.ToLower(p.Name)] = true
+		}
+	}
+	for _, st := range idl.structs {
+		for _, field := range st.Fields {
+			fields[strings.ToLower(field.Name)] = true
+		}
+	}
+
+	redact := map[string]bool{}
+	for _, name := range opts.Redact {
+		if !fields[strings.ToLower(name)] {
+			panic(fmt.Sprintf("barrister: LogOptions.Redact name not found in IDL: %s", name))
+		}
+		redact[strings.ToLower(name)] = true
+	}
+	return &LogFilter{idl, sink, opts, redact}
+}
+
+// PreInvoke records the start time of the request, and arranges for the
+// record to be written when the call returns
+func (f *LogFilter) PreInvoke(r *RequestResponse) bool {
+	r.Context = context.WithValue(r.Context, logStartKey{}, time.Now())
+	if call := r.Headers.call; call != nil {
+		call.onDone = append(call.onDone, func() { f.log(r, call.err) })
+	}
+	return true
+}
+
+// PostInvoke writes the record for the request, if PreInvoke could not
+// arrange for it to be written when the call returns
+func (f *LogFilter) PostInvoke(r *RequestResponse) bool {
+	if r.Headers.call == nil {
+		f.log(r, r.Err)
+	}
+	return true
+}
+
+// log writes the record for a request that returned err
+func (f *LogFilter) log(r *RequestResponse, err error) {
+	var duration time.Duration
+	start, ok := r.Context.Value(logStartKey{}).(time.Time)
+	if ok {
+		duration = time.Since(start)
+	}
+
+	paramsBytes := 0
+	if b, merr := json.Marshal(r.Params); merr == nil {
+		paramsBytes = len(b)
+	}
+
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.Duration("duration", duration),
+		slog.Int("params_bytes", paramsBytes),
+	}
+
+	if err != nil {
+		e := toJsonRpcError(r.Method, err)
+		level = slog.LevelWarn
+		if e.Code == -32603 || e.Code == -32000 {
+			level = slog.LevelError
+		}
+		attrs = append(attrs, slog.Int("error_code", e.Code), slog.String("error", e.Message))
+	}
+
+	if len(f.opts.Headers) > 0 {
+		headers := make([]interface{}, 0, len(f.opts.Headers))
+		for _, name := range f.opts.Headers {
+			headers = append(headers, slog.String(name, GetFirst(r.Headers.Request, name)))
+		}
+		attrs = append(attrs, slog.Group("headers", headers...))
+	}
+
+	if f.opts.LogParams {
+		attrs = append(attrs, slog.Any("params", f.namedParams(r.Method, r.Params)))
+	}
+
+	f.sink.LogAttrs(r.Context, level, f.opts.Message, attrs...)
+}
+
+// namedParams returns the params keyed by IDL param name, with redacted
+// values replaced
+func (f *LogFilter) namedParams(method string, params []interface{}) map[string]interface{} {
+	idlFunc := f.idl.Method(method)
+	named := make(map[string]interface{}, len(params))
+	for i, p := range params {
+		name := fmt.Sprintf("param%d", i)
+		if i < len(idlFunc.Params) {
+			name = idlFunc.Params[i].Name
+		}
+
+		if f.redact[strings.ToLower(name)] {
+			named[name] = "[REDACTED]"
+		} else {
+			named[name] = f.redactValue(p)
+		}
+	}
+	return named
+}
+
+// redactValue returns a copy of v with the redacted struct fields replaced.
+// Params decoded from a request are maps and []interface{}.  Other structs,
+// pointers, maps and slices, e.g. Go values passed to Server.Call, are
+// converted to that form through their JSON encoding first, so fields are
+// matched by their JSON names.
+func (f *LogFilter) redactValue(v interface{}) interface{} {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Struct, reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Array:
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+		default:
+			var generic interface{}
+			b, err := json.Marshal(v)
+			if err == nil {
+				err = json.Unmarshal(b, &generic)
+			}
+			if err != nil {
+				// don't risk logging fields that should be redacted
+				return "[REDACTED]"
+			}
+			v = generic
+		}
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			if f.redact[strings.ToLower(k)] {
+				m[k] = "[REDACTED]"
+			} else {
+				m[k] = f.redactValue(val)
+			}
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(t))
+		for i, val := range t {
+			arr[i] = f.redactValue(val)
+		}
+		return arr
+	}
+	return v
+}
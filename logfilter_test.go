@@ -0,0 +1,119 @@
+package barrister
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestLogFilter(t *testing.T) {
+	idl := parseTestIdl()
+	svr := NewJSONServer(idl, true)
+	svr.AddHandler("A", AImpl{})
+	svr.AddHandler("B", BImpl{context: &Context{}})
+
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	svr.AddFilter(NewLogFilter(idl, logger, LogOptions{Headers: []string{"X-Request-Id"},
+		LogParams: true, Redact: []string{"email"}}))
+
+	headers := newHeaders()
+	headers.Request["X-Request-Id"] = []string{"r1"}
+	person := map[string]interface{}{"personId": "p1", "firstName": "a", "lastName": "b", "email": "a@b.com"}
+	resultOk(svr.Call(headers, "A.putPerson", person))
+
+	var rec map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec["method"] != "A.putPerson" || rec["level"] != "INFO" || rec["params_bytes"].(float64) <= 0 {
+		t.Errorf("unexpected record: %v", rec)
+	}
+	if rec["headers"].(map[string]interface{})["X-Request-Id"] != "r1" {
+		t.Errorf("header not logged: %v", rec)
+	}
+	p := rec["params"].(map[string]interface{})["p"].(map[string]interface{})
+	if p["email"] != "[REDACTED]" || p["personId"] != "p1" {
+		t.Errorf("params not redacted: %v", p)
+	}
+	if person["email"] != "a@b.com" {
+		t.Errorf("redaction modified request params")
+	}
+
+	// requests rejected before filters run are not logged
+	buf.Reset()
+	svr.Call(headers, "A.add", 1)
+	if buf.Len() != 0 {
+		t.Errorf("unexpected record: %s", buf.String())
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("NewLogFilter allowed Redact name not in IDL")
+		}
+	}()
+	NewLogFilter(idl, logger, LogOptions{Redact: []string{"passwrd"}})
+}
+
+func TestLogFilterRedactsStructParams(t *testing.T) {
+	idl := parseTestIdl()
+	svr := NewJSONServer(idl, true)
+	svr.AddHandler("A", AImpl{})
+
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	svr.AddFilter(NewLogFilter(idl, logger, LogOptions{LogParams: true, Redact: []string{"email"}}))
+
+	email := "a@b.com"
+	resultOk(svr.Call(newHeaders(), "A.putPerson", Person{PersonId: "p1", Email: &email}))
+
+	if bytes.Contains(buf.Bytes(), []byte(email)) {
+		t.Errorf("struct param not redacted: %s", buf.String())
+	}
+	var rec map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := rec["params"].(map[string]interface{})["p"].(map[string]interface{})
+	if p["email"] != "[REDACTED]" || p["personId"] != "p1" {
+		t.Errorf("params not redacted: %v", p)
+	}
+}
+
+func TestLogFilterLogsPanicsAndRejectedCalls(t *testing.T) {
+	svr := newCounterServer()
+	buf := &bytes.Buffer{}
+	svr.AddFilter(NewLogFilter(svr.idl, slog.New(slog.NewJSONHandler(buf, nil)), LogOptions{}))
+	svr.AddFilter(ProxyFilter{
+		pre: func(r *RequestResponse) bool {
+			if r.Params[0] == 2 {
+				r.Err = &JsonRpcError{Code: -32001, Message: "rejected"}
+				return false
+			}
+			return true
+		},
+		post: func(r *RequestResponse) bool { return true },
+	})
+
+	_, err := svr.Call(newHeaders(), "Counter.total", -1)
+	expectErrCode(t, err, -32603)
+	_, err = svr.Call(newHeaders(), "Counter.total", 2)
+	expectErrCode(t, err, -32001)
+
+	dec := json.NewDecoder(buf)
+	for _, code := range []float64{-32603, -32001} {
+		var rec map[string]interface{}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("call with code %v not logged: %v", code, err)
+		}
+		if rec["method"] != "Counter.total" || rec["error_code"] != code {
+			t.Errorf("unexpected record: %v", rec)
+		}
+	}
+	if dec.More() {
+		t.Errorf("unexpected records after the expected ones")
+	}
+}
@@ -0,0 +1,57 @@
+package barrister
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// ResponseMeta describes the transport level response to a call.
+// Request headers are sent per call with WithRequestHeader.
+type ResponseMeta struct {
+	// Response headers, including those the server's handler wrote to
+	// Headers.Response.  Set by HttpTransport.
+	Header http.Header
+
+	// HTTP status code.  Set by HttpTransport.
+	StatusCode int
+
+	// Time taken by the call, including any retries
+	Duration time.Duration
+}
+
+type responseMetaKey struct{}
+
+// WithResponseMeta returns a copy of ctx that carries meta.  Transports that
+// support metadata fill in meta when a request is sent with the returned
+// context.  If a call is retried, meta describes the last attempt.
+func WithResponseMeta(ctx context.Context, meta *ResponseMeta) context.Context {
+	return context.WithValue(ctx, responseMetaKey{}, meta)
+}
+
+// ResponseMetaFromContext returns the ResponseMeta carried by ctx, or nil.
+// Transports call this to report the metadata of a response.
+func ResponseMetaFromContext(ctx context.Context) *ResponseMeta {
+	meta, _ := ctx.Value(responseMetaKey{}).(*ResponseMeta)
+	return meta
+}
+
+// CallWithMeta invokes method on c and returns the result along with the
+// response metadata.  c should implement ContextClient, otherwise only
+// ResponseMeta.Duration is set.
+//
+// For example, to send a request header and read a response header:
+//
+//     ctx := barrister.WithRequestHeader(context.Background(), "X-Request-Id", reqId)
+//     res, meta, err := barrister.CallWithMeta(ctx, client, "Calculator.add", 1, 2)
+//     if err == nil {
+//         fmt.Println(meta.Header.Get("X-Server-Version"), meta.Duration)
+//     }
+//
+func CallWithMeta(ctx context.Context, c Client, method string, params ...interface{}) (interface{}, *ResponseMeta, error) {
+	meta := &ResponseMeta{}
+	start := time.Now()
+	result, err := CallContext(WithResponseMeta(ctx, meta), c, method, params...)
+	meta.Duration = time.Since(start)
+	return result, meta, err
+}
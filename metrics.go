@@ -0,0 +1,218 @@
+package barrister
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the latency
+// histogram buckets used if NewMetrics is passed nil
+var DefaultLatencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects request counts, error counts by JsonRpcError code, and
+// latency histograms per IDL method, for servers (via Filter) and clients
+// (via Interceptor).  Metrics implements http.Handler, and serves the
+// collected metrics in the Prometheus text exposition format:
+//
+//     metrics := barrister.NewMetrics(nil)
+//     svr.AddFilter(metrics.Filter())
+//     client.AddInterceptor(metrics.Interceptor())
+//     http.Handle("/metrics", metrics)
+//
+// Server side, requests are recorded when the call returns, so panics,
+// invalid params and requests terminated by the PreInvoke of a later Filter
+// are counted.  Requests rejected before the filter chain runs (e.g. unknown
+// methods) or terminated by the PreInvoke of an earlier Filter are not.
+type Metrics struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[metricKey]*methodMetrics
+}
+
+// metricKey identifies the metrics of one method on one side ("server" or "client")
+type metricKey struct {
+	side   string
+	method string
+}
+
+type methodMetrics struct {
+	requests uint64
+	errors   map[int]uint64
+
+	// count per bucket, not cumulative.  The last element counts
+	// observations above the largest bucket.
+	buckets []uint64
+	sum     float64
+}
+
+type metricsStartKey struct{}
+
+// NewMetrics creates an empty Metrics.  buckets are the upper bounds, in
+// seconds, of the latency histogram buckets.  If nil, DefaultLatencyBuckets
+// are used.
+func NewMetrics(buckets []float64) *Metrics {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	b := append([]float64{}, buckets...)
+	sort.Float64s(b)
+	return &Metrics{buckets: b, series: map[metricKey]*methodMetrics{}}
+}
+
+// Filter returns a Filter that records the metrics of each request handled
+// by a Server
+func (m *Metrics) Filter() Filter {
+	return metricsFilter{m}
+}
+
+// Interceptor returns an Interceptor that records the metrics of each call
+// made by a RemoteClient
+func (m *Metrics) Interceptor() Interceptor {
+	return metricsInterceptor{m}
+}
+
+// observe records a single request
+func (m *Metrics) observe(side, method string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := metricKey{side, method}
+	mm, ok := m.series[key]
+	if !ok {
+		mm = &methodMetrics{errors: map[int]uint64{}, buckets: make([]uint64, len(m.buckets)+1)}
+		m.series[key] = mm
+	}
+
+	mm.requests++
+	if err != nil {
+		mm.errors[toJsonRpcError(method, err).Code]++
+	}
+
+	secs := duration.Seconds()
+	mm.sum += secs
+	i := sort.SearchFloat64s(m.buckets, secs)
+	mm.buckets[i]++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(m.Text())
+}
+
+// Text returns the metrics in the Prometheus text exposition format
+func (m *Metrics) Text() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]metricKey, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].method < keys[j].method })
+
+	b := &bytes.Buffer{}
+	for _, side := range []string{"server", "client"} {
+		var sideKeys []metricKey
+		for _, k := range keys {
+			if k.side == side {
+				sideKeys = append(sideKeys, k)
+			}
+		}
+		if len(sideKeys) == 0 {
+			continue
+		}
+
+		name := "barrister_" + side + "_requests_total"
+		fmt.Fprintf(b, "# HELP %s Total requests, by IDL method.\n# TYPE %s counter\n", name, name)
+		for _, k := range sideKeys {
+			fmt.Fprintf(b, "%s{method=\"%s\"} %d\n", name, escapeLabel(k.method), m.series[k].requests)
+		}
+
+		name = "barrister_" + side + "_errors_total"
+		fmt.Fprintf(b, "# HELP %s Total failed requests, by IDL method and JSON-RPC error code.\n# TYPE %s counter\n", name, name)
+		for _, k := range sideKeys {
+			mm := m.series[k]
+			codes := make([]int, 0, len(mm.errors))
+			for code := range mm.errors {
+				codes = append(codes, code)
+			}
+			sort.Ints(codes)
+			for _, code := range codes {
+				fmt.Fprintf(b, "%s{method=\"%s\",code=\"%d\"} %d\n", name, escapeLabel(k.method), code, mm.errors[code])
+			}
+		}
+
+		name = "barrister_" + side + "_request_duration_seconds"
+		fmt.Fprintf(b, "# HELP %s Request latency, by IDL method.\n# TYPE %s histogram\n", name, name)
+		for _, k := range sideKeys {
+			mm := m.series[k]
+			method := escapeLabel(k.method)
+			var cumulative uint64
+			for i, le := range m.buckets {
+				cumulative += mm.buckets[i]
+				fmt.Fprintf(b, "%s_bucket{method=\"%s\",le=\"%s\"} %d\n", name, method,
+					strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+			}
+			fmt.Fprintf(b, "%s_bucket{method=\"%s\",le=\"+Inf\"} %d\n", name, method, mm.requests)
+			fmt.Fprintf(b, "%s_sum{method=\"%s\"} %s\n", name, method, strconv.FormatFloat(mm.sum, 'g', -1, 64))
+			fmt.Fprintf(b, "%s_count{method=\"%s\"} %d\n", name, method, mm.requests)
+		}
+	}
+	return b.Bytes()
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return strings.Replace(s, "\n", `\n`, -1)
+}
+
+// metricsFilter is returned by Metrics.Filter
+type metricsFilter struct {
+	m *Metrics
+}
+
+func (f metricsFilter) PreInvoke(r *RequestResponse) bool {
+	start := time.Now()
+	r.Context = context.WithValue(r.Context, metricsStartKey{}, start)
+	if call := r.Headers.call; call != nil {
+		call.onDone = append(call.onDone, func() {
+			f.m.observe("server", r.Method, time.Since(start), call.err)
+		})
+	}
+	return true
+}
+
+func (f metricsFilter) PostInvoke(r *RequestResponse) bool {
+	if r.Headers.call == nil {
+		start, _ := r.Context.Value(metricsStartKey{}).(time.Time)
+		f.m.observe("server", r.Method, time.Since(start), r.Err)
+	}
+	return true
+}
+
+// metricsInterceptor is returned by Metrics.Interceptor
+type metricsInterceptor struct {
+	m *Metrics
+}
+
+func (i metricsInterceptor) PreCall(c *ClientCall) bool {
+	c.Context = context.WithValue(c.Context, metricsStartKey{}, time.Now())
+	return true
+}
+
+func (i metricsInterceptor) PostCall(c *ClientCall) bool {
+	start, _ := c.Context.Value(metricsStartKey{}).(time.Time)
+	i.m.observe("client", c.Method, time.Since(start), c.Err)
+	return true
+}
@@ -0,0 +1,87 @@
+package barrister
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics([]float64{0.5, 1})
+
+	svr := newCounterServer()
+	svr.AddFilter(metrics.Filter())
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	client := NewRemoteClient(&HttpTransport{Url: ts.URL}, true).(*RemoteClient)
+	client.AddInterceptor(metrics.Interceptor())
+
+	client.Call("Counter.total", 4)
+	client.Call("Counter.total", 5)
+	client.Call("Counter.count", -1)
+
+	ms := httptest.NewServer(metrics)
+	defer ms.Close()
+	resp, err := ms.Client().Get(ms.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	text := string(b)
+
+	expected := []string{
+		"# TYPE barrister_server_requests_total counter",
+		`barrister_server_requests_total{method="Counter.total"} 2`,
+		`barrister_server_errors_total{method="Counter.count",code="1000"} 1`,
+		`barrister_server_request_duration_seconds_bucket{method="Counter.total",le="0.5"} 2`,
+		`barrister_server_request_duration_seconds_bucket{method="Counter.total",le="+Inf"} 2`,
+		`barrister_server_request_duration_seconds_count{method="Counter.count"} 1`,
+		`barrister_client_requests_total{method="Counter.count"} 1`,
+		`barrister_client_errors_total{method="Counter.count",code="1000"} 1`,
+		"# TYPE barrister_client_request_duration_seconds histogram",
+	}
+	for _, line := range expected {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("metrics missing line: %s\n%s", line, text)
+		}
+	}
+}
+
+func TestMetricsCountsPanicsAndRejectedCalls(t *testing.T) {
+	metrics := NewMetrics(nil)
+	svr := newCounterServer()
+	svr.AddFilter(metrics.Filter())
+	svr.AddFilter(ProxyFilter{
+		pre: func(r *RequestResponse) bool {
+			if r.Params[0] == 2 {
+				r.Err = &JsonRpcError{Code: -32001, Message: "rejected"}
+				return false
+			}
+			return true
+		},
+		post: func(r *RequestResponse) bool { return true },
+	})
+
+	_, err := svr.Call(newHeaders(), "Counter.total", -1)
+	expectErrCode(t, err, -32603)
+	_, err = svr.Call(newHeaders(), "Counter.total", "x")
+	expectErrCode(t, err, -32602)
+	_, err = svr.Call(newHeaders(), "Counter.total", 2)
+	expectErrCode(t, err, -32001)
+
+	text := string(metrics.Text())
+	expected := []string{
+		`barrister_server_requests_total{method="Counter.total"} 3`,
+		`barrister_server_errors_total{method="Counter.total",code="-32603"} 1`,
+		`barrister_server_errors_total{method="Counter.total",code="-32001"} 1`,
+		`barrister_server_errors_total{method="Counter.total",code="-32602"} 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("metrics missing line: %s\n%s", line, text)
+		}
+	}
+}
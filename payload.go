@@ -0,0 +1,83 @@
+package barrister
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+)
+
+// PayloadLimits bound the size and complexity of the requests a Server
+// accepts.  Requests that exceed a limit fail with code -32600.  Zero values
+// are unlimited.
+type PayloadLimits struct {
+	// Maximum size of an HTTP request body, or of a request line read by
+	// ServeConn
+	MaxBodyBytes int64
+
+	// Maximum number of requests in a batch.  Each request of a longer
+	// batch fails.
+	MaxBatchLength int
+
+	// Maximum number of elements in an array param, or an array field of a
+	// struct param
+	MaxArrayLength int
+
+	// Maximum nesting of arrays and structs within a param.  A param that is
+	// an array of structs has depth 2.
+	MaxDepth int
+}
+
+// DefaultPayloadLimits are the PayloadLimits of a new Server
+var DefaultPayloadLimits = PayloadLimits{
+	MaxBodyBytes:   10 << 20,
+	MaxBatchLength: 1000,
+	MaxDepth:       64,
+}
+
+// SetPayloadLimits replaces the PayloadLimits of the Server
+func (s *Server) SetPayloadLimits(limits PayloadLimits) {
+	s.payloadLimits = limits
+}
+
+// limitError is returned by convert when a param exceeds the PayloadLimits
+type limitError struct {
+	path string
+	msg  string
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("barrister: %s: %s", e.path, e.msg)
+}
+
+// checkLimits returns an error if the array or struct being converted by c,
+// which has the given length, exceeds c.limits
+func (c *convert) checkLimits(length int) error {
+	if c.limits == nil {
+		return nil
+	}
+	if c.limits.MaxDepth > 0 && c.depth+1 > c.limits.MaxDepth {
+		return &limitError{c.path, fmt.Sprintf("nesting exceeds maximum depth of %d", c.limits.MaxDepth)}
+	}
+	if c.limits.MaxArrayLength > 0 && length > c.limits.MaxArrayLength {
+		return &limitError{c.path, fmt.Sprintf("array length %d exceeds maximum of %d", length, c.limits.MaxArrayLength)}
+	}
+	return nil
+}
+
+// readLine reads a line from r, without the trailing newline.  If the line
+// is longer than max bytes, the rest of it is discarded and tooLong is true.
+// max <= 0 is unlimited.
+func readLine(r *bufio.Reader, max int64) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			if max > 0 && int64(len(bytes.TrimSuffix(line, []byte("\n")))) > max {
+				tooLong, line = true, nil
+			}
+		}
+		if err != bufio.ErrBufferFull {
+			return bytes.TrimSuffix(line, []byte("\n")), tooLong, err
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package barrister
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func limitedConformServer(limits PayloadLimits) Server {
+	svr := NewJSONServer(parseTestIdl(), true)
+	svr.AddHandler("A", AImpl{})
+	svr.AddHandler("B", BImpl{})
+	svr.SetPayloadLimits(limits)
+	return svr
+}
+
+func TestPayloadBodyTooLarge(t *testing.T) {
+	svr := limitedConformServer(PayloadLimits{MaxBodyBytes: 64})
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	body := `{"jsonrpc":"2.0","id":"1","method":"B.echo","params":["` + strings.Repeat("x", 100) + `"]}`
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d != 413", resp.StatusCode)
+	}
+
+	rpcResp := JsonRpcResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&rpcResp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rpcResp.Error == nil || rpcResp.Error.Code != -32600 {
+		t.Errorf("expected -32600 error, got: %+v", rpcResp.Error)
+	}
+
+	// requests within the limit succeed
+	resp, err = http.Post(ts.URL, "application/json",
+		strings.NewReader(`{"jsonrpc":"2.0","id":"1","method":"B.echo","params":["hi"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d != 200", resp.StatusCode)
+	}
+}
+
+func TestPayloadBatchTooLong(t *testing.T) {
+	svr := limitedConformServer(PayloadLimits{MaxBatchLength: 2})
+	call := `{"jsonrpc":"2.0","id":"1","method":"A.add","params":[1,2]}`
+	call2 := strings.Replace(call, `"id":"1"`, `"id":"2"`, 1)
+
+	// each element of a batch that is too long fails with its own id
+	batchResp := []JsonRpcResponse{}
+	err := json.Unmarshal(svr.InvokeBytes(newHeaders(), []byte("["+call+","+call2+","+call+"]")), &batchResp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batchResp) != 3 || batchResp[1].Id != "2" {
+		t.Fatalf("unexpected batch response: %+v", batchResp)
+	}
+	for _, resp := range batchResp {
+		if resp.Error == nil || resp.Error.Code != -32600 {
+			t.Errorf("expected -32600 error, got: %+v", resp.Error)
+		}
+	}
+
+	batchResp = []JsonRpcResponse{}
+	err = json.Unmarshal(svr.InvokeBytes(newHeaders(), []byte("["+call+","+call+"]")), &batchResp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batchResp) != 2 || batchResp[0].Error != nil {
+		t.Errorf("unexpected batch response: %+v", batchResp)
+	}
+}
+
+func TestPayloadArrayTooLong(t *testing.T) {
+	svr := limitedConformServer(PayloadLimits{MaxArrayLength: 3})
+
+	_, err := svr.Call(newHeaders(), "A.calc", []interface{}{1.0, 2.0, 3.0, 4.0}, "add")
+	expectErrCode(t, err, -32600)
+
+	res, err := svr.Call(newHeaders(), "A.calc", []interface{}{1.0, 2.0, 3.0}, "add")
+	if err != nil || res != float64(6) {
+		t.Errorf("unexpected result: %v %v", res, err)
+	}
+}
+
+func TestPayloadMaxDepth(t *testing.T) {
+	idl := createTestIdl()
+	nestField := &Field{Type: "Nested", Optional: false, IsArray: false}
+	input := map[string]interface{}{"name": "hi",
+		"Nest": map[string]interface{}{"E": []interface{}{"a", "b"}}}
+
+	for maxDepth, ok := range map[int]bool{0: true, 2: false, 3: true} {
+		conv := newConvert(idl, nestField, reflect.TypeOf(Nested{}), input, "param[0]")
+		conv.limits = &PayloadLimits{MaxDepth: maxDepth}
+		_, err := conv.run()
+		if ok && err != nil {
+			t.Errorf("MaxDepth %d: unexpected error: %v", maxDepth, err)
+		} else if !ok {
+			if _, isLimit := err.(*limitError); !isLimit {
+				t.Errorf("MaxDepth %d: expected limitError, got: %v", maxDepth, err)
+			}
+		}
+	}
+}
+
+func TestPayloadConnLineTooLong(t *testing.T) {
+	svr := limitedConformServer(PayloadLimits{MaxBodyBytes: 64})
+	client, server := net.Pipe()
+	go svr.ServeConn(server, newHeaders())
+	defer client.Close()
+
+	go func() {
+		client.Write([]byte(`{"jsonrpc":"2.0","id":"1","method":"B.echo","params":["` +
+			strings.Repeat("x", 5000) + `"]}` + "\n"))
+	}()
+
+	// the error is written, then the connection is closed
+	b, err := ioutil.ReadAll(client)
+	if err != nil && err != io.ErrClosedPipe {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	tooLong := JsonRpcResponse{}
+	json.Unmarshal(lines[0], &tooLong)
+	if len(lines) != 1 || tooLong.Error == nil || tooLong.Error.Code != -32600 {
+		t.Errorf("expected -32600 error, got: %s", b)
+	}
+}
+
+func TestPayloadConnTransportLimits(t *testing.T) {
+	svr := limitedConformServer(PayloadLimits{MaxBodyBytes: 256, MaxBatchLength: 2})
+
+	serverConn, clientConn := net.Pipe()
+	go svr.ServeConn(serverConn, newHeaders())
+	trans := NewConnTransport(clientConn)
+	client := NewRemoteClient(trans, false)
+	defer trans.Close()
+
+	batch := []JsonRpcRequest{}
+	for _, id := range []string{"1", "2", "3"} {
+		batch = append(batch, JsonRpcRequest{Jsonrpc: "2.0", Id: id, Method: "A.add", Params: []interface{}{1, 2}})
+	}
+	batchResp := client.CallBatch(batch)
+	if len(batchResp) != 3 || batchResp[2].Id != "3" || batchResp[2].Error == nil ||
+		batchResp[2].Error.Code != -32600 {
+		t.Errorf("unexpected batch response: %+v", batchResp)
+	}
+
+	// a request line that is too long fails the pending requests
+	_, err := client.Call("B.echo", strings.Repeat("x", 500))
+	if err == nil {
+		t.Errorf("expected error")
+	}
+	_, err = client.Call("B.echo", "hi")
+	if err == nil {
+		t.Errorf("expected error after the connection was closed")
+	}
+}
@@ -0,0 +1,66 @@
+package barrister
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicInfo describes a panic recovered while handling a request
+type PanicInfo struct {
+	// Random id that is also returned to the caller in the error, so that
+	// the caller's report can be matched to the server log
+	Id string
+
+	// Method being called. e.g. "Calculator.add"
+	Method string
+
+	// Value passed to panic
+	Value interface{}
+
+	// Stack trace of the goroutine that panicked
+	Stack []byte
+}
+
+// SetPanicHandler registers a func that is called with each panic recovered
+// from a handler or Filter.  Use it to log panics or report them to an error
+// tracker.  By default recovered panics are not reported.
+func (s *Server) SetPanicHandler(handler func(p *PanicInfo)) {
+	s.panicHandler = handler
+}
+
+// SetDebug controls whether errors returned for recovered panics include the
+// panic value and stack trace in JsonRpcError.Data.  It should not be enabled
+// for servers exposed to untrusted callers.
+func (s *Server) SetDebug(debug bool) {
+	s.debug = debug
+}
+
+// recoverPanic converts a panic in Server.call into a -32603 error.  It must
+// be called directly by a deferred statement.
+func (s *Server) recoverPanic(method string, result *interface{}, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	*result = nil
+	*err = s.panicError(method, r, debug.Stack())
+}
+
+// panicError reports a recovered panic to the panic handler, and returns the
+// error returned to the caller
+func (s *Server) panicError(method string, r interface{}, stack []byte) *JsonRpcError {
+	p := &PanicInfo{Id: randHex(8), Method: method, Value: r, Stack: stack}
+	if s.panicHandler != nil {
+		s.panicHandler(p)
+	}
+
+	data := map[string]interface{}{"id": p.Id}
+	if s.debug {
+		data["panic"] = fmt.Sprintf("%v", r)
+		data["stack"] = string(p.Stack)
+	}
+
+	return &JsonRpcError{Code: -32603, Data: data,
+		Message: fmt.Sprintf("barrister: method '%s' panicked (id=%s)", method, p.Id)}
+}
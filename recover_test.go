@@ -0,0 +1,88 @@
+package barrister
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	svr := newCounterServer()
+
+	var panics []*PanicInfo
+	svr.SetPanicHandler(func(p *PanicInfo) {
+		panics = append(panics, p)
+	})
+
+	_, err := svr.Call(newHeaders(), "Counter.total", -1)
+	e, ok := err.(*JsonRpcError)
+	if !ok || e.Code != -32603 {
+		t.Fatalf("expected -32603 error, got: %v", err)
+	}
+	if len(panics) != 1 || panics[0].Method != "Counter.total" || !strings.Contains(e.Message, panics[0].Id) {
+		t.Errorf("panic not reported with matching id: %v %v", panics, e)
+	}
+	if _, ok := e.Data.(map[string]interface{})["stack"]; ok {
+		t.Errorf("stack trace included when not in debug mode")
+	}
+
+	svr.SetDebug(true)
+	_, err = svr.Call(newHeaders(), "Counter.total", -1)
+	data := err.(*JsonRpcError).Data.(map[string]interface{})
+	if data["panic"] != "n must be positive" || data["stack"] == "" {
+		t.Errorf("debug data missing: %v", data)
+	}
+}
+
+func TestRecoverPanicInBatch(t *testing.T) {
+	svr := newCounterServer()
+
+	req := `[{"jsonrpc":"2.0","id":"1","method":"Counter.total","params":[-1]},
+	         {"jsonrpc":"2.0","id":"2","method":"Counter.total","params":[4]}]`
+	var resp []JsonRpcResponse
+	err := json.Unmarshal(svr.InvokeBytes(newHeaders(), []byte(req)), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp) != 2 || resp[0].Error == nil || resp[0].Error.Code != -32603 {
+		t.Errorf("panic not converted to error: %+v", resp)
+	} else if resp[1].Error != nil || resp[1].Result != 6.0 {
+		t.Errorf("panic affected other batch element: %+v", resp[1])
+	}
+}
+
+// errFilter records the error seen by PostInvoke
+type errFilter struct {
+	errs *[]error
+}
+
+func (f errFilter) PreInvoke(r *RequestResponse) bool {
+	return true
+}
+
+func (f errFilter) PostInvoke(r *RequestResponse) bool {
+	*f.errs = append(*f.errs, r.Err)
+	return true
+}
+
+func TestPostInvokeSeesPanicsAndBadParams(t *testing.T) {
+	svr := newCounterServer()
+	var errs []error
+	svr.AddFilter(errFilter{&errs})
+
+	_, err := svr.Call(newHeaders(), "Counter.total", -1)
+	expectErrCode(t, err, -32603)
+	_, err = svr.Call(newHeaders(), "Counter.total", "x")
+	expectErrCode(t, err, -32602)
+	resultOk(svr.Call(newHeaders(), "Counter.total", 3))
+
+	if len(errs) != 3 {
+		t.Fatalf("PostInvoke called %d times, expected 3", len(errs))
+	}
+	expectErrCode(t, errs[0], -32603)
+	expectErrCode(t, errs[1], -32602)
+	if errs[2] != nil {
+		t.Errorf("unexpected error: %v", errs[2])
+	}
+}
@@ -1,6 +1,7 @@
 package barrws
 
 import (
+	"context"
 	"github.com/coopernurse/barrister-go"
 	"github.com/coopernurse/retina/ws"
 	"log"
@@ -44,3 +45,21 @@ func (me *Runner) Run() {
 	}
 	retinaws.BackendServer(me.Url, me.Workers, handler, me.Shutdown)
 }
+
+// Stop sends on the Shutdown channel so that no more messages are consumed,
+// then shuts down each Server, waiting for the calls in flight to return.
+// Stop returns the context's error if ctx is done first.
+func (me *Runner) Stop(ctx context.Context) error {
+	select {
+	case me.Shutdown <- true:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for _, server := range me.Servers {
+		err := server.Shutdown(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package barrister
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures automatic retries of failed calls made by a
+// RemoteClient.  A call is retried if the Transport failed, or if the server
+// returned an error whose code is listed in RetryCodes, but only if
+// Idempotent reports that the method is safe to call more than once, or the
+// call carries an idempotency key (see IdempotencyKeys).
+//
+// Batch calls and streams are never retried.
+//
+// Example:
+//
+//     idl := barrister.MustParseIdlJson([]byte(generated.IdlJsonRaw))
+//     client := &barrister.RemoteClient{
+//         Trans: trans,
+//         Ser:   &barrister.JsonSerializer{ForceASCII: true},
+//         Retry: &barrister.RetryPolicy{MaxAttempts: 3, Idempotent: idl.IsIdempotent},
+//     }
+//
+type RetryPolicy struct {
+	// Total number of attempts, including the first.  Values less than 2
+	// disable retries.
+	MaxAttempts int
+
+	// Delay before the first retry.  Defaults to 100ms.
+	InitialBackoff time.Duration
+
+	// Upper bound on the delay between attempts.  Defaults to 5s.
+	MaxBackoff time.Duration
+
+	// Factor the delay grows by after each retry.  Defaults to 2.
+	Multiplier float64
+
+	// Fraction of each delay, from 0 to 1, that is randomized so that
+	// many clients do not retry in lockstep.  0 disables jitter.
+	Jitter float64
+
+	// JSON-RPC error codes returned by the server that should be retried,
+	// in addition to Transport errors.  e.g. a code your servers return
+	// when overloaded.
+	RetryCodes []int
+
+	// Reports whether method may be retried.  If nil, only calls with an
+	// idempotency key are retried.
+	// Idl.IsIdempotent may be used to retry only the functions annotated
+	// with @idempotent in the IDL.
+	Idempotent func(method string) bool
+}
+
+// IsIdempotent returns true if the function with the given name is annotated
+// with @idempotent in the IDL, meaning that calling it more than once with the
+// same params has the same effect as calling it once.
+func (idl *Idl) IsIdempotent(method string) bool {
+	idlFunc, ok := idl.methods[method]
+	if !ok {
+		return false
+	}
+	_, ok = idlFunc.Annotation("idempotent")
+	return ok
+}
+
+// shouldRetry returns true if the given attempt at calling method failed with
+// an error that may be retried.  keyed is true if the call has an idempotency key.
+func (p *RetryPolicy) shouldRetry(method string, keyed bool, attempt int, err *JsonRpcError, transportErr bool) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if !keyed && (p.Idempotent == nil || !p.Idempotent(method)) {
+		return false
+	}
+	if transportErr {
+		return true
+	}
+	for _, code := range p.RetryCodes {
+		if err.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay to wait after the given attempt failed
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = 5 * time.Second
+	}
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+
+	d := float64(delay)
+	for i := 1; i < attempt && d < float64(max); i++ {
+		d *= mult
+	}
+	if d > float64(max) {
+		d = float64(max)
+	}
+
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= d * jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// wait sleeps for the backoff of the given attempt, and returns false
+// if ctx is done first
+func (p *RetryPolicy) wait(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(p.backoff(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,114 @@
+package barrister
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// ErrCodeShuttingDown is the JsonRpcError code returned for calls received
+// after Server.Shutdown was called
+const ErrCodeShuttingDown = -32006
+
+// drainState tracks the calls in flight on a Server, so that Shutdown can
+// wait for them.  It is shared by copies of the Server.
+type drainState struct {
+	mu       sync.Mutex
+	inFlight int
+	closing  bool
+	idle     chan struct{}
+}
+
+// enter records the start of a call.  It returns false if the Server is
+// shutting down, in which case the call must not proceed.
+func (d *drainState) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closing {
+		return false
+	}
+	d.inFlight++
+	return true
+}
+
+// leave records the end of a call started with enter
+func (d *drainState) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.closing && d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+// close stops new calls from entering, and returns a channel that is
+// closed once no calls are in flight
+func (d *drainState) close() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.closing {
+		d.closing = true
+		d.idle = make(chan struct{})
+		if d.inFlight == 0 {
+			close(d.idle)
+		}
+	}
+	return d.idle
+}
+
+func (d *drainState) isClosing() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closing
+}
+
+// shutdownError is returned for calls received while shutting down
+func shutdownError() *JsonRpcError {
+	return &JsonRpcError{Code: ErrCodeShuttingDown, Message: "barrister: server shutting down"}
+}
+
+// InFlight returns the number of calls being handled by the Server
+func (s *Server) InFlight() int {
+	s.drain.mu.Lock()
+	defer s.drain.mu.Unlock()
+	return s.drain.inFlight
+}
+
+// Shutdown stops the Server from accepting new calls, then waits for the
+// calls in flight to return.  Calls received after Shutdown is called fail
+// with code ErrCodeShuttingDown, and ServeHTTP responds to them with status
+// 503.  Shutdown can not be undone.
+//
+// If ctx is done before the calls in flight return, Shutdown returns the
+// context's error.  Handlers abandoned after a timeout (see SetTimeout) are
+// not waited for.
+//
+// Shutdown does not close listeners or connections.  Use ShutdownWith to
+// shut down the Server along with an http.Server, and close connections
+// passed to ServeConn once Shutdown returns.
+func (s *Server) Shutdown(ctx context.Context) error {
+	idle := s.drain.close()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ShutdownWith arranges for the Server to stop accepting calls when
+// hs.Shutdown is called.  hs.Shutdown closes its listeners and waits for
+// active connections, including the calls in flight on them, so after
+//
+//     svr.ShutdownWith(hs)
+//     go hs.ListenAndServe()
+//     ...
+//     hs.Shutdown(ctx)
+//
+// no calls are running on hs.  Calls made on keep-alive connections while
+// hs is shutting down fail with ErrCodeShuttingDown.
+func (s *Server) ShutdownWith(hs *http.Server) {
+	hs.RegisterOnShutdown(func() {
+		s.drain.close()
+	})
+}
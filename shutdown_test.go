@@ -0,0 +1,100 @@
+package barrister
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdownWaitsForInFlight(t *testing.T) {
+	svr, _ := newSlowServer()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := svr.Call(newHeaders(), "Counter.total", 100)
+		done <- err
+	}()
+	for svr.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	err := svr.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Shutdown returned before the call: %s", time.Since(start))
+	}
+	if err := <-done; err != nil {
+		t.Errorf("in-flight call failed: %v", err)
+	}
+	if svr.InFlight() != 0 {
+		t.Errorf("InFlight %d != 0", svr.InFlight())
+	}
+
+	_, err = svr.Call(newHeaders(), "Counter.total", 1)
+	expectErrCode(t, err, ErrCodeShuttingDown)
+}
+
+func TestShutdownContextDone(t *testing.T) {
+	svr, _ := newSlowServer()
+
+	go svr.Call(newHeaders(), "Counter.total", 500)
+	for svr.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := svr.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got: %v", err)
+	}
+
+	// a second Shutdown waits for the same calls
+	err = svr.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+}
+
+func TestShutdownHTTP(t *testing.T) {
+	svr := newCounterServer()
+	ts := httptest.NewUnstartedServer(&svr)
+	svr.ShutdownWith(ts.Config)
+	ts.Start()
+	defer ts.Close()
+
+	body := `{"jsonrpc":"2.0","id":"1","method":"Counter.total","params":[3]}`
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d != 200", resp.StatusCode)
+	}
+
+	err = ts.Config.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("http.Server.Shutdown failed: %v", err)
+	}
+	// RegisterOnShutdown funcs run in their own goroutine
+	for i := 0; i < 100 && !svr.drain.isClosing(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	svr.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d != 503", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "-32006") {
+		t.Errorf("expected ErrCodeShuttingDown, got: %s", rec.Body.String())
+	}
+}
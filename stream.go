@@ -0,0 +1,407 @@
+package barrister
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// StreamMimeType is the Content-Type of HTTP responses that contain
+// a sequence of StreamFrame messages, one per line.
+const StreamMimeType = "application/x-ndjson"
+
+// StreamFrame is a single message of a streamed response.  A stream consists
+// of zero or more frames that carry an Item, followed by a final frame that
+// has either Done set or a non-nil Error.
+type StreamFrame struct {
+	// Version of the JSON-RPC protocol.  Always "2.0"
+	Jsonrpc string `json:"jsonrpc"`
+
+	// Id will match the related JsonRpcRequest.Id
+	Id string `json:"id"`
+
+	// A single item of the result
+	Item interface{} `json:"item,omitempty"`
+
+	// True on the final frame of a successful stream
+	Done bool `json:"done,omitempty"`
+
+	// Set on the final frame if the stream failed
+	Error *JsonRpcError `json:"error,omitempty"`
+}
+
+// newSender creates a value of the handler's sender func type that passes
+// each item to send.
+func newSender(senderType reflect.Type, send func(item interface{}) error) reflect.Value {
+	return reflect.MakeFunc(senderType, func(args []reflect.Value) []reflect.Value {
+		errVal := reflect.New(typeOfError).Elem()
+		err := send(args[0].Interface())
+		if err != nil {
+			errVal.Set(reflect.ValueOf(err))
+		}
+		return []reflect.Value{errVal}
+	})
+}
+
+//////////////////////////////////////////////////
+// Server //
+////////////
+
+// CallStream handles a single request for a stream function.  The handler
+// method is passed a sender func, and each item the handler sends is passed
+// to send.  If send returns an error (e.g. the caller has disconnected) the
+// error is returned by the handler's sender func.
+//
+// Request processing is otherwise identical to Call.  Filters are invoked
+// before the first item is sent and after the handler returns.
+func (s *Server) CallStream(headers Headers, method string, send func(item interface{}) error, params ...interface{}) error {
+	idlFunc, ok := s.idl.methods[method]
+	if ok && !idlFunc.IsStream() {
+		return &JsonRpcError{Code: -32601, Message: fmt.Sprintf("Method %s is not a stream function", method)}
+	}
+
+	_, err := s.call(headers, method, params, send)
+	return err
+}
+
+// InvokeStream handles a single request for a stream function.  Each StreamFrame
+// is serialized and passed to write as soon as it is produced.
+//
+// The error from writing the final frame is returned.  In that case the
+// caller did not receive the end of the stream, and should close the
+// connection rather than leave the caller waiting for it.
+func (s *Server) InvokeStream(headers Headers, rpcReq *JsonRpcRequest, write func(frame []byte) error) error {
+	send := func(item interface{}) error {
+		return s.writeFrame(&StreamFrame{Jsonrpc: "2.0", Id: rpcReq.Id, Item: item}, write)
+	}
+	headers = reservedRequestHeaders(headers, rpcReq)
+
+	var err error
+	arr, ok := rpcReq.Params.([]interface{})
+	if ok {
+		err = s.CallStream(headers, rpcReq.Method, send, arr...)
+	} else {
+		err = s.CallStream(headers, rpcReq.Method, send)
+	}
+
+	last := &StreamFrame{Jsonrpc: "2.0", Id: rpcReq.Id, Done: err == nil,
+		Error: toJsonRpcError(rpcReq.Method, err)}
+	return s.writeFrame(last, write)
+}
+
+func (s *Server) writeFrame(frame *StreamFrame, write func(frame []byte) error) error {
+	b, err := s.ser.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return write(b)
+}
+
+// isStreamReq returns true if rpcReq asks for a streamed response and the
+// method is a stream function.
+func (s *Server) isStreamReq(rpcReq *JsonRpcRequest) bool {
+	return rpcReq.Stream && s.idl.methods[rpcReq.Method].IsStream()
+}
+
+// serveStream writes each StreamFrame to w followed by a newline, and flushes
+// w after each frame so the caller receives items as they are produced.
+//
+// Headers.Response is sent before the first frame, so handlers must set
+// response headers before sending their first item.
+//
+// If the final frame can't be written, the connection is aborted so the
+// caller sees a truncated response instead of a stream that ends cleanly.
+func (s *Server) serveStream(w http.ResponseWriter, headers Headers, rpcReq *JsonRpcRequest) {
+	flusher, _ := w.(http.Flusher)
+	wroteHeader := false
+
+	write := func(frame []byte) error {
+		if !wroteHeader {
+			w.Header().Set("Content-Type", StreamMimeType)
+			for k, v := range headers.Response {
+				for _, s := range v {
+					w.Header().Add(k, s)
+				}
+			}
+			wroteHeader = true
+		}
+
+		_, err := w.Write(append(frame, '\n'))
+		if err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	err := s.InvokeStream(headers, rpcReq, write)
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+//////////////////////////////////////////////////
+// Client //
+////////////
+
+// StreamTransport is implemented by Transports that can deliver the frames
+// of a streamed response as they arrive.
+type StreamTransport interface {
+	SendStream(in []byte) (FrameReader, error)
+}
+
+// FrameReader reads the serialized frames of a streamed response
+type FrameReader interface {
+	// ReadFrame returns the next frame, or io.EOF if there are no more frames
+	ReadFrame() ([]byte, error)
+
+	// Close releases the underlying connection.  Close may be called
+	// before all frames have been read.
+	Close() error
+}
+
+// lineFrameReader is a FrameReader for newline delimited frames
+type lineFrameReader struct {
+	r *bufio.Reader
+	c io.Closer
+}
+
+func (l *lineFrameReader) ReadFrame() ([]byte, error) {
+	for {
+		line, err := l.r.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (l *lineFrameReader) Close() error {
+	return l.c.Close()
+}
+
+// SendStream posts the request and returns a FrameReader over the response body.
+// HttpHook.After is called with a nil body.
+func (t *HttpTransport) SendStream(in []byte) (FrameReader, error) {
+	req, resp, err := t.post(context.Background(), in, StreamMimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Hook != nil {
+		t.Hook.After(req, resp, nil)
+	}
+
+	return &lineFrameReader{bufio.NewReader(resp.Body), resp.Body}, nil
+}
+
+// StreamClient is implemented by Clients that can deliver the result of
+// a stream function incrementally.
+type StreamClient interface {
+	CallStream(method string, params ...interface{}) (*StreamIterator, error)
+}
+
+// CallStream invokes a stream function on c.  If c implements StreamClient
+// the items are delivered as they are produced, otherwise c.Call is used
+// and the iterator walks the complete result.
+func CallStream(c Client, method string, params ...interface{}) (*StreamIterator, error) {
+	sc, ok := c.(StreamClient)
+	if ok {
+		return sc.CallStream(method, params...)
+	}
+
+	result, err := c.Call(method, params...)
+	if err != nil {
+		return nil, err
+	}
+	return newSliceIterator(method, result)
+}
+
+// CallStream invokes a stream function and returns an iterator over the items
+// of its result.  If the Transport implements StreamTransport the items are
+// read as the server produces them.  Otherwise the complete result is read
+// before the first item is returned.
+func (c *RemoteClient) CallStream(method string, params ...interface{}) (*StreamIterator, error) {
+	st, ok := c.Trans.(StreamTransport)
+	if !ok {
+		result, err := c.Call(method, params...)
+		if err != nil {
+			return nil, err
+		}
+		return newSliceIterator(method, result)
+	}
+
+	rpcReq := JsonRpcRequest{Jsonrpc: "2.0", Id: randHex(20), Method: method, Params: params, Stream: true}
+
+	reqBytes, err := c.Ser.Marshal(rpcReq)
+	if err != nil {
+		msg := fmt.Sprintf("barrister: %s: CallStream unable to Marshal request: %s", method, err)
+		return nil, &JsonRpcError{Code: -32600, Message: msg}
+	}
+
+	frames, err := st.SendStream(reqBytes)
+	if err != nil {
+		msg := fmt.Sprintf("barrister: %s: Transport error during request: %s", method, err)
+		return nil, &JsonRpcError{Code: -32603, Message: msg}
+	}
+
+	return &StreamIterator{method: method, frames: frames, ser: c.Ser}, nil
+}
+
+// StreamIterator iterates over the items of a stream function result.
+//
+// Typical usage:
+//
+//     it, err := client.CallStream("A.repeat_num", 10, 1000)
+//     if err != nil {
+//         return err
+//     }
+//     defer it.Close()
+//     for it.Next() {
+//         fmt.Println(it.Item())
+//     }
+//     return it.Err()
+//
+type StreamIterator struct {
+	method string
+
+	// set if items are read from a streamed response
+	frames FrameReader
+	ser    Serializer
+
+	// set if the complete result was returned at once
+	items reflect.Value
+	pos   int
+
+	item interface{}
+	err  error
+	done bool
+}
+
+// streamMessage is a StreamFrame, or a plain JsonRpcResponse from a server
+// that does not support streaming
+type streamMessage struct {
+	StreamFrame
+	Result json.RawMessage `json:"result"`
+}
+
+func newSliceIterator(method string, result interface{}) (*StreamIterator, error) {
+	it := &StreamIterator{method: method}
+	if result != nil {
+		it.items = reflect.ValueOf(result)
+		if it.items.Kind() != reflect.Slice {
+			msg := fmt.Sprintf("%s returned invalid type for stream: %v", method, it.items.Type())
+			return nil, &JsonRpcError{Code: -32000, Message: msg}
+		}
+	}
+	return it, nil
+}
+
+// Next advances the iterator to the next item, which is then available
+// through Item.  Next returns false when the stream is complete or an error
+// occurs.  Err should be consulted to distinguish the two cases.
+func (it *StreamIterator) Next() bool {
+	if it.done {
+		return false
+	}
+
+	if it.frames == nil {
+		if it.items.IsValid() && it.pos < it.items.Len() {
+			it.item = it.items.Index(it.pos).Interface()
+			it.pos++
+			return true
+		}
+		it.finish(nil)
+		return false
+	}
+
+	b, err := it.frames.ReadFrame()
+	if err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("stream ended before final frame")
+		}
+		msg := fmt.Sprintf("barrister: %s: Transport error during stream: %s", it.method, err)
+		it.finish(&JsonRpcError{Code: -32603, Message: msg})
+		return false
+	}
+
+	var frame streamMessage
+	err = it.ser.Unmarshal(b, &frame)
+	if err != nil {
+		msg := fmt.Sprintf("barrister: %s: CallStream unable to Unmarshal frame: %s", it.method, err)
+		it.finish(&JsonRpcError{Code: -32603, Message: msg})
+		return false
+	}
+
+	if frame.Error != nil {
+		it.finish(frame.Error)
+		return false
+	}
+
+	if frame.Result != nil {
+		// server replied with the complete result
+		it.frames.Close()
+		it.frames = nil
+
+		var result interface{}
+		err = it.ser.Unmarshal(frame.Result, &result)
+		if err == nil {
+			var sliceIt *StreamIterator
+			sliceIt, err = newSliceIterator(it.method, result)
+			if err == nil {
+				it.items = sliceIt.items
+				return it.Next()
+			}
+		}
+		it.finish(toJsonRpcError(it.method, err))
+		return false
+	}
+
+	if frame.Done {
+		it.finish(nil)
+		return false
+	}
+
+	it.item = frame.Item
+	return true
+}
+
+// Item returns the current item
+func (it *StreamIterator) Item() interface{} {
+	return it.item
+}
+
+// Err returns the error that terminated the stream, or nil if the stream
+// completed successfully
+func (it *StreamIterator) Err() error {
+	return it.err
+}
+
+// Close releases the underlying connection.  It is safe to call Close before
+// the stream is complete, and to call Close more than once.
+func (it *StreamIterator) Close() error {
+	it.done = true
+	if it.frames != nil {
+		frames := it.frames
+		it.frames = nil
+		return frames.Close()
+	}
+	return nil
+}
+
+func (it *StreamIterator) finish(err *JsonRpcError) {
+	if err != nil {
+		it.err = err
+	}
+	it.Close()
+}
@@ -0,0 +1,238 @@
+package barrister
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var streamIdlJson = `[{
+    "type": "interface",
+    "name": "Counter",
+    "comment": "",
+    "functions": [{
+        "name": "count",
+        "comment": "returns 0..n-1\n@stream",
+        "params": [{"name": "n", "type": "int", "optional": false, "is_array": false, "comment": ""}],
+        "returns": {"name": "", "type": "int", "optional": false, "is_array": true, "comment": ""}
+    }, {
+        "name": "total",
+        "comment": "@idempotent",
+        "params": [{"name": "n", "type": "int", "optional": false, "is_array": false, "comment": ""}],
+        "returns": {"name": "", "type": "int", "optional": false, "is_array": false, "comment": ""}
+    }, {
+        "name": "changed",
+        "comment": "@event",
+        "params": [{"name": "name", "type": "string", "optional": false, "is_array": false, "comment": ""}],
+        "returns": {"name": "", "type": "int", "optional": false, "is_array": false, "comment": ""}
+    }]
+}, {
+    "type": "meta",
+    "barrister_version": "0.1.6",
+    "date_generated": 1400000000000,
+    "checksum": "abc"
+}]`
+
+type CounterSender func(item int64) error
+
+type CounterImpl struct{}
+
+func (c CounterImpl) Count(n int64, out CounterSender) error {
+	for i := int64(0); i < n; i++ {
+		err := out(i)
+		if err != nil {
+			return err
+		}
+	}
+	if n < 0 {
+		return &JsonRpcError{Code: 1000, Message: "n must be positive"}
+	}
+	return nil
+}
+
+func (c CounterImpl) Total(n int64) (int64, error) {
+	if n < 0 {
+		panic("n must be positive")
+	}
+	return n * (n - 1) / 2, nil
+}
+
+type CounterImpl_NoSender struct{}
+
+func (c CounterImpl_NoSender) Count(n int64) ([]int64, error) {
+	return nil, nil
+}
+
+func (c CounterImpl_NoSender) Total(n int64) (int64, error) {
+	return 0, nil
+}
+
+func newCounterServer() Server {
+	svr := NewJSONServer(MustParseIdlJson([]byte(streamIdlJson)), true)
+	svr.AddHandler("Counter", CounterImpl{})
+	return svr
+}
+
+func readAll(t *testing.T, it *StreamIterator) []interface{} {
+	items := []interface{}{}
+	for it.Next() {
+		items = append(items, it.Item())
+	}
+	if it.Err() != nil {
+		t.Errorf("stream failed: %v", it.Err())
+	}
+	return items
+}
+
+func TestParseAnnotations(t *testing.T) {
+	a := parseAnnotations("some text\n  @stream\n@cache 30s\n@")
+	expected := map[string]string{"stream": "", "cache": "30s"}
+	if !reflect.DeepEqual(a, expected) {
+		t.Errorf("%v != %v", a, expected)
+	}
+
+	idl := MustParseIdlJson([]byte(streamIdlJson))
+	if !idl.Method("Counter.count").IsStream() {
+		t.Errorf("Counter.count is not a stream")
+	}
+	if idl.Method("Counter.total").IsStream() {
+		t.Errorf("Counter.total is a stream")
+	}
+}
+
+func TestStreamCallCollectsItems(t *testing.T) {
+	svr := newCounterServer()
+
+	res, err := svr.Call(newHeaders(), "Counter.count", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, []int64{0, 1, 2}) {
+		t.Errorf("%v != [0 1 2]", res)
+	}
+}
+
+func TestStreamCallStream(t *testing.T) {
+	svr := newCounterServer()
+
+	items := []interface{}{}
+	send := func(item interface{}) error {
+		items = append(items, item)
+		if len(items) == 2 {
+			return fmt.Errorf("caller went away")
+		}
+		return nil
+	}
+
+	err := svr.CallStream(newHeaders(), "Counter.count", send, 5)
+	if err == nil || len(items) != 2 {
+		t.Errorf("send error did not stop stream. err=%v items=%v", err, items)
+	}
+
+	err = svr.CallStream(newHeaders(), "Counter.total", send, 5)
+	e, ok := err.(*JsonRpcError)
+	if !ok || e.Code != -32601 {
+		t.Errorf("CallStream on non-stream function didn't fail: %v", err)
+	}
+}
+
+func TestStreamAddHandlerPanicsWithoutSender(t *testing.T) {
+	svr := NewJSONServer(MustParseIdlJson([]byte(streamIdlJson)), true)
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("AddHandler allowed stream handler without sender")
+		}
+	}()
+	svr.AddHandler("Counter", CounterImpl_NoSender{})
+}
+
+func TestStreamHttp(t *testing.T) {
+	svr := newCounterServer()
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	client := NewRemoteClient(&HttpTransport{Url: ts.URL}, true)
+
+	it, err := CallStream(client, "Counter.count", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := readAll(t, it)
+	if !reflect.DeepEqual(items, []interface{}{0.0, 1.0, 2.0}) {
+		t.Errorf("%v != [0 1 2]", items)
+	}
+
+	// errors are returned after the last item
+	it, err = CallStream(client, "Counter.count", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.Next() {
+		t.Errorf("unexpected item: %v", it.Item())
+	}
+	e, ok := it.Err().(*JsonRpcError)
+	if !ok || e.Code != 1000 {
+		t.Errorf("expected code 1000 error, got: %v", it.Err())
+	}
+
+	// plain calls receive the complete result
+	res, err := client.Call("Counter.count", 2)
+	if err != nil || !reflect.DeepEqual(res, []interface{}{0.0, 1.0}) {
+		t.Errorf("Call returned %v %v", res, err)
+	}
+}
+
+func TestStreamConn(t *testing.T) {
+	svr := newCounterServer()
+	serverConn, clientConn := net.Pipe()
+	go svr.ServeConn(serverConn, newHeaders())
+
+	trans := NewConnTransport(clientConn)
+	defer trans.Close()
+	client := NewRemoteClient(trans, false)
+
+	it, err := CallStream(client, "Counter.count", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := readAll(t, it)
+	if !reflect.DeepEqual(items, []interface{}{0.0, 1.0, 2.0, 3.0}) {
+		t.Errorf("%v != [0 1 2 3]", items)
+	}
+
+	// closing a stream early does not block other calls
+	it, err = CallStream(client, "Counter.count", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it.Next()
+	it.Close()
+
+	res, err := client.Call("Counter.total", 4)
+	if err != nil || res != 6.0 {
+		t.Errorf("Call returned %v %v", res, err)
+	}
+}
+
+func TestInvokeStreamReturnsWriteError(t *testing.T) {
+	svr := newCounterServer()
+	frames := 0
+	write := func(frame []byte) error {
+		frames++
+		if bytes.Contains(frame, []byte(`"done"`)) {
+			return io.ErrClosedPipe
+		}
+		return nil
+	}
+
+	rpcReq := &JsonRpcRequest{Jsonrpc: "2.0", Id: "1", Method: "Counter.count", Params: []interface{}{2}, Stream: true}
+	err := svr.InvokeStream(newHeaders(), rpcReq, write)
+	if err != io.ErrClosedPipe || frames != 3 {
+		t.Errorf("expected ErrClosedPipe after 3 frames, got: %v after %d", err, frames)
+	}
+}
@@ -0,0 +1,196 @@
+package barrister
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCodeTimeout is the JsonRpcError code returned for calls whose handler
+// did not return before the call's deadline, or whose caller went away
+const ErrCodeTimeout = -32005
+
+// TimeoutHeader is the request header in which clients send how long, in
+// milliseconds, they will wait for the response.  HttpTransport sends it for
+// calls whose context has a deadline.  Clients without headers may set
+// JsonRpcRequest.TimeoutMs.
+const TimeoutHeader = "Barrister-Timeout"
+
+// SetTimeout sets the maximum time a handler may run.  Zero, the default,
+// means no limit.  SetMethodTimeout overrides it for a single method.
+//
+// The deadline of a call is the sooner of this timeout and the caller's
+// TimeoutHeader.  When it passes, the context returned by Headers.Context
+// is done, and the call fails with code ErrCodeTimeout without waiting for
+// the handler to return.  Handlers should check the context and return
+// early, as the result of an abandoned handler is discarded.  Stream
+// functions may not send items once their call has failed.
+//
+// An abandoned handler still counts as in flight until it returns: it holds
+// its Limiter slot, delays Shutdown, and is counted by InFlight and the
+// MethodStats.
+//
+// SetTimeout must not be called while the Server is handling requests.
+func (s *Server) SetTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
+
+// SetMethodTimeout sets the maximum time the handler of method may run,
+// overriding SetTimeout.  A negative timeout means no limit for the method.
+// SetMethodTimeout panics if method is not a function in the IDL.
+// SetMethodTimeout must not be called while the Server is handling requests.
+func (s *Server) SetMethodTimeout(method string, timeout time.Duration) {
+	if _, ok := s.idl.methods[method]; !ok {
+		panic(fmt.Sprintf("barrister: IDL has no function: %s", method))
+	}
+	s.methodTimeouts[method] = timeout
+}
+
+// callContext returns the context of a call to method, bounded by the
+// server and caller timeouts.  timeout is zero if the call has no deadline.
+func (s *Server) callContext(headers Headers, method string) (ctx context.Context, timeout time.Duration, cancel context.CancelFunc) {
+	ctx = headers.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timeout = s.timeout
+	if t, ok := s.methodTimeouts[method]; ok {
+		timeout = t
+	}
+	if timeout < 0 {
+		timeout = 0
+	}
+
+	ms, err := strconv.ParseInt(GetFirst(headers.Request, TimeoutHeader), 10, 64)
+	if err == nil && ms > 0 {
+		callerTimeout := time.Duration(ms) * time.Millisecond
+		if timeout == 0 || callerTimeout < timeout {
+			timeout = callerTimeout
+		}
+	}
+
+	if timeout == 0 {
+		ctx, cancel = context.WithCancel(ctx)
+		return ctx, 0, cancel
+	}
+	ctx, cancel = context.WithTimeout(ctx, timeout)
+	return ctx, timeout, cancel
+}
+
+// invokeHandler calls fn with args.  If the call has a timeout, fn runs in its
+// own goroutine, and invokeHandler returns a timeout error once ctx is done
+// without waiting for fn.  A panic in that goroutine is returned as a -32603
+// error, as it can not be recovered by Server.call.
+//
+// closeSend is called before invokeHandler returns, so that an abandoned
+// stream handler can not send items.  If fn is abandoned, rel is delayed
+// until fn returns, so the call stays in flight until then.
+func (s *Server) invokeHandler(ctx context.Context, timeout time.Duration, method string,
+	fn reflect.Value, args []reflect.Value, closeSend func(), rel *releaser) (ret []reflect.Value, panicked, err error) {
+	if timeout == 0 {
+		return fn.Call(args), nil, nil
+	}
+	defer closeSend()
+
+	type outcome struct {
+		ret      []reflect.Value
+		panicked error
+	}
+	done := make(chan outcome, 1)
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{panicked: s.panicError(method, r, debug.Stack())}
+			}
+		}()
+		done <- outcome{ret: fn.Call(args)}
+	}()
+
+	select {
+	case o := <-done:
+		return o.ret, o.panicked, nil
+	case <-ctx.Done():
+		rel.waitFor(returned)
+		return nil, nil, timeoutError(ctx, method, timeout)
+	}
+}
+
+// releaser holds the funcs that end the bookkeeping of a call, e.g. the
+// release of its Limiter slot and its in-flight count
+type releaser struct {
+	funcs   []func()
+	pending <-chan struct{}
+}
+
+// add adds f to the funcs called by run
+func (r *releaser) add(f func()) {
+	r.funcs = append(r.funcs, f)
+}
+
+// waitFor delays the funcs called by run until done is closed
+func (r *releaser) waitFor(done <-chan struct{}) {
+	r.pending = done
+}
+
+// run calls the funcs in reverse order, now or once the handler of an
+// abandoned call returns
+func (r *releaser) run() {
+	release := func() {
+		for i := len(r.funcs) - 1; i >= 0; i-- {
+			r.funcs[i]()
+		}
+	}
+	if r.pending == nil {
+		release()
+		return
+	}
+	go func() {
+		<-r.pending
+		release()
+	}()
+}
+
+// timeoutError returns the error for a call whose context is done
+func timeoutError(ctx context.Context, method string, timeout time.Duration) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &JsonRpcError{Code: ErrCodeTimeout, Message: fmt.Sprintf("barrister: %s timed out after %s", method, timeout)}
+	}
+	return &JsonRpcError{Code: ErrCodeTimeout, Message: fmt.Sprintf("barrister: %s was canceled", method)}
+}
+
+// guardSend returns a send func that fails once closeSend is called
+func guardSend(ctx context.Context, send func(item interface{}) error) (guarded func(item interface{}) error, closeSend func()) {
+	var mu sync.Mutex
+	closed := false
+	guarded = func(item interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return fmt.Errorf("barrister: stream closed: %v", ctx.Err())
+		}
+		return send(item)
+	}
+	closeSend = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+	}
+	return guarded, closeSend
+}
+
+// timeoutMillis returns the milliseconds until deadline, sent in the
+// TimeoutHeader.  A deadline that has passed is sent as 1ms.
+func timeoutMillis(deadline time.Time) int64 {
+	ms := time.Until(deadline).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
+}
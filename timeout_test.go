@@ -0,0 +1,193 @@
+package barrister
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowCounter sleeps n milliseconds in Total, unless its context is done first
+type slowCounter struct {
+	headers Headers
+	ctxErrs chan error
+}
+
+func (c slowCounter) CloneForReq(headers Headers) interface{} {
+	return slowCounter{headers, c.ctxErrs}
+}
+
+func (c slowCounter) Count(n int64, out CounterSender) error {
+	for i := int64(0); i < n; i++ {
+		time.Sleep(5 * time.Millisecond)
+		err := out(i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c slowCounter) Total(n int64) (int64, error) {
+	if n < 0 {
+		panic("n must be positive")
+	}
+	select {
+	case <-time.After(time.Duration(n) * time.Millisecond):
+		return n, nil
+	case <-c.headers.Context().Done():
+		c.ctxErrs <- c.headers.Context().Err()
+		return 0, c.headers.Context().Err()
+	}
+}
+
+func newSlowServer() (Server, chan error) {
+	ctxErrs := make(chan error, 10)
+	svr := NewJSONServer(MustParseIdlJson([]byte(streamIdlJson)), true)
+	svr.AddHandler("Counter", slowCounter{ctxErrs: ctxErrs})
+	return svr, ctxErrs
+}
+
+func TestServerTimeout(t *testing.T) {
+	svr, ctxErrs := newSlowServer()
+	svr.SetTimeout(20 * time.Millisecond)
+
+	start := time.Now()
+	_, err := svr.Call(newHeaders(), "Counter.total", 5000)
+	expectErrCode(t, err, ErrCodeTimeout)
+	if time.Since(start) > time.Second {
+		t.Errorf("timeout not enforced: %s", time.Since(start))
+	}
+	if e := <-ctxErrs; e != context.DeadlineExceeded {
+		t.Errorf("handler context not done: %v", e)
+	}
+
+	res, err := svr.Call(newHeaders(), "Counter.total", 1)
+	if err != nil || res != int64(1) {
+		t.Errorf("fast call failed: %v %v", res, err)
+	}
+
+	svr.SetMethodTimeout("Counter.total", -1)
+	_, err = svr.Call(newHeaders(), "Counter.total", 40)
+	if err != nil {
+		t.Errorf("call failed with timeout disabled: %v", err)
+	}
+
+	// panics in handlers with a timeout are recovered
+	svr.SetMethodTimeout("Counter.total", time.Second)
+	_, err = svr.Call(newHeaders(), "Counter.total", -1)
+	expectErrCode(t, err, -32603)
+}
+
+// stubbornCounter ignores its context and returns from Total when release
+// is closed
+type stubbornCounter struct {
+	CounterImpl
+	release chan struct{}
+}
+
+func (c stubbornCounter) Total(n int64) (int64, error) {
+	<-c.release
+	return n, nil
+}
+
+func TestServerTimeoutAbandonedHandlerInFlight(t *testing.T) {
+	release := make(chan struct{})
+	svr := NewJSONServer(MustParseIdlJson([]byte(streamIdlJson)), true)
+	svr.AddHandler("Counter", stubbornCounter{release: release})
+	svr.EnableInfo("1.0")
+	limiter := NewLimiter()
+	limiter.SetLimit("Counter.total", Limit{MaxInFlight: 1})
+	svr.AddFilter(limiter)
+	svr.SetTimeout(10 * time.Millisecond)
+
+	_, err := svr.Call(newHeaders(), "Counter.total", 1)
+	expectErrCode(t, err, ErrCodeTimeout)
+
+	// the abandoned handler keeps its slot and delays Shutdown
+	if svr.InFlight() != 1 {
+		t.Errorf("InFlight() = %d, expected 1", svr.InFlight())
+	}
+	_, err = svr.Call(newHeaders(), "Counter.total", 1)
+	expectErrCode(t, err, ErrCodeLimitExceeded)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	err = svr.Shutdown(ctx)
+	cancel()
+	if err == nil {
+		t.Errorf("Shutdown returned while a handler was running")
+	}
+	if stats := svr.methodStats(); stats[1].InFlight != 1 || stats[1].Calls != 1 {
+		t.Errorf("unexpected stats: %+v", stats[1])
+	}
+
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = svr.Shutdown(ctx)
+	if err != nil || svr.InFlight() != 0 {
+		t.Errorf("Shutdown: %v, InFlight: %d", err, svr.InFlight())
+	}
+	// the stats are recorded after the in-flight count is released
+	deadline := time.Now().Add(time.Second)
+	stats := svr.methodStats()
+	for stats[1].InFlight != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		stats = svr.methodStats()
+	}
+	if stats[1].InFlight != 0 || stats[1].Calls != 2 || stats[1].Errors != 2 {
+		t.Errorf("unexpected stats: %+v", stats[1])
+	}
+}
+
+func TestServerTimeoutStream(t *testing.T) {
+	svr, _ := newSlowServer()
+	svr.SetTimeout(30 * time.Millisecond)
+
+	var mu sync.Mutex
+	items := 0
+	err := svr.CallStream(newHeaders(), "Counter.count", func(item interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		items++
+		return nil
+	}, 1000)
+	expectErrCode(t, err, ErrCodeTimeout)
+
+	mu.Lock()
+	sent := items
+	mu.Unlock()
+	time.Sleep(30 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if sent == 0 || sent >= 1000 || items != sent {
+		t.Errorf("items sent after timeout: %d then %d", sent, items)
+	}
+}
+
+func TestClientTimeoutHeader(t *testing.T) {
+	svr, ctxErrs := newSlowServer()
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	_, err := CallContext(ctx, NewRemoteClient(&HttpTransport{Url: ts.URL}, true), "Counter.total", 5000)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	select {
+	case e := <-ctxErrs:
+		if e == nil {
+			t.Errorf("handler context not done")
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("handler did not observe the caller's deadline")
+	}
+
+	h := newHeaders()
+	h.Request[TimeoutHeader] = []string{"20"}
+	_, err = svr.Call(h, "Counter.total", 5000)
+	expectErrCode(t, err, ErrCodeTimeout)
+}
@@ -0,0 +1,266 @@
+package barrister
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracing follows the W3C Trace Context recommendation, so Barrister services
+// can take part in traces with any OpenTelemetry instrumented service.
+//
+// The trace context is sent in the HTTP "traceparent" header.  It is also sent
+// in the reserved "traceparent" member of the JSON-RPC request
+// (JsonRpcRequest.Traceparent), which servers use if the header is absent, so
+// that traces propagate over transports without headers such as ConnTransport.
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceId [16]byte
+	SpanId  [8]byte
+	Sampled bool
+}
+
+// IsValid returns true if the trace and span ids are not zero
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceId != [16]byte{} && sc.SpanId != [8]byte{}
+}
+
+// Traceparent returns sc in the W3C traceparent header format
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceId[:]), hex.EncodeToString(sc.SpanId[:]), flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value
+func ParseTraceparent(s string) (SpanContext, error) {
+	sc := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("barrister: invalid traceparent: %s", s)
+	}
+
+	_, err1 := hex.Decode(sc.TraceId[:], []byte(parts[1]))
+	_, err2 := hex.Decode(sc.SpanId[:], []byte(parts[2]))
+	flags, err3 := hex.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil || !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("barrister: invalid traceparent: %s", s)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// SpanKind is the role of a span in a call
+type SpanKind int
+
+const (
+	SpanKindServer SpanKind = iota
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	if k == SpanKindClient {
+		return "client"
+	}
+	return "server"
+}
+
+// Span records a single call, made or handled
+type Span struct {
+	// IDL method. e.g. "Calculator.add"
+	Name string
+
+	Kind        SpanKind
+	SpanContext SpanContext
+
+	// Zero if the span is the root of its trace
+	Parent SpanContext
+
+	Start time.Time
+	End   time.Time
+
+	// rpc.system, rpc.service and rpc.method, plus
+	// rpc.jsonrpc.error_code and rpc.jsonrpc.error_message if the call failed
+	Attributes map[string]interface{}
+
+	// Error returned by the call, if any
+	Err error
+}
+
+// SpanExporter receives each sampled span after it ends
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+// InMemoryExporter keeps exported spans in memory.  It is intended for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// ExportSpan appends span to the exported spans
+func (e *InMemoryExporter) ExportSpan(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the exported spans in the order they ended
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span{}, e.spans...)
+}
+
+// Reset discards the exported spans
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx that carries span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Tracer creates server spans with its Filter, and client spans with its
+// Interceptor, and passes them to a SpanExporter when they end.
+//
+//     exporter := &barrister.InMemoryExporter{}
+//     tracer := barrister.NewTracer(exporter)
+//     svr.AddFilter(tracer.Filter())
+//     client.AddInterceptor(tracer.Interceptor())
+//
+// Server spans are children of the caller's span, if the request carries
+// a trace context.  Client spans are children of the span carried by the
+// call's context (see ContextWithSpan and CallContext), so calls that a
+// handler makes with the Headers.Context of a traced request continue its
+// trace.
+//
+// A new trace is always sampled.  Spans of unsampled traces are propagated
+// but not exported.
+type Tracer struct {
+	exporter SpanExporter
+}
+
+// NewTracer creates a Tracer that exports spans to exporter
+func NewTracer(exporter SpanExporter) *Tracer {
+	return &Tracer{exporter}
+}
+
+// Filter returns a Filter that creates a server span for each request.
+// The span ends when the call returns, so panics, invalid params and
+// requests rejected by a later Filter are exported with their error.
+// It should be registered before other Filters so their time is included.
+func (t *Tracer) Filter() Filter {
+	return traceFilter{t}
+}
+
+// Interceptor returns an Interceptor that creates a client span for each call
+func (t *Tracer) Interceptor() Interceptor {
+	return traceInterceptor{t}
+}
+
+// start creates a span that is a child of parent, or a root span if parent
+// is not valid
+func (t *Tracer) start(kind SpanKind, method string, parent SpanContext) *Span {
+	sc := SpanContext{TraceId: parent.TraceId, Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		rand.Read(sc.TraceId[:])
+		sc.Sampled = true
+	}
+	rand.Read(sc.SpanId[:])
+
+	iface, fname := method, ""
+	if i := strings.Index(method, "."); i > -1 {
+		iface, fname = method[:i], method[i+1:]
+	}
+
+	return &Span{Name: method, Kind: kind, SpanContext: sc, Parent: parent, Start: time.Now(),
+		Attributes: map[string]interface{}{
+			"rpc.system":  "barrister",
+			"rpc.service": iface,
+			"rpc.method":  fname,
+		}}
+}
+
+// end completes the span and exports it if sampled
+func (t *Tracer) end(span *Span, err error) {
+	span.End = time.Now()
+	if err != nil {
+		e := toJsonRpcError(span.Name, err)
+		span.Err = err
+		span.Attributes["rpc.jsonrpc.error_code"] = e.Code
+		span.Attributes["rpc.jsonrpc.error_message"] = e.Message
+	}
+	if span.SpanContext.Sampled {
+		t.exporter.ExportSpan(span)
+	}
+}
+
+// traceFilter is returned by Tracer.Filter
+type traceFilter struct {
+	t *Tracer
+}
+
+func (f traceFilter) PreInvoke(r *RequestResponse) bool {
+	parent, _ := ParseTraceparent(GetFirst(r.Headers.Request, "Traceparent"))
+	span := f.t.start(SpanKindServer, r.Method, parent)
+	r.Context = ContextWithSpan(r.Context, span)
+	if call := r.Headers.call; call != nil {
+		call.onDone = append(call.onDone, func() { f.t.end(span, call.err) })
+	}
+	return true
+}
+
+func (f traceFilter) PostInvoke(r *RequestResponse) bool {
+	if r.Headers.call != nil {
+		return true
+	}
+	span := SpanFromContext(r.Context)
+	if span != nil && span.Kind == SpanKindServer && span.End.IsZero() {
+		f.t.end(span, r.Err)
+	}
+	return true
+}
+
+// traceInterceptor is returned by Tracer.Interceptor
+type traceInterceptor struct {
+	t *Tracer
+}
+
+func (i traceInterceptor) PreCall(c *ClientCall) bool {
+	var parent SpanContext
+	if p := SpanFromContext(c.Context); p != nil {
+		parent = p.SpanContext
+	}
+
+	span := i.t.start(SpanKindClient, c.Method, parent)
+	c.Context = setRequestHeader(ContextWithSpan(c.Context, span), "Traceparent", span.SpanContext.Traceparent())
+	return true
+}
+
+func (i traceInterceptor) PostCall(c *ClientCall) bool {
+	span := SpanFromContext(c.Context)
+	if span != nil && span.Kind == SpanKindClient && span.End.IsZero() {
+		i.t.end(span, c.Err)
+	}
+	return true
+}
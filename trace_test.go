@@ -0,0 +1,169 @@
+package barrister
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	s := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(s)
+	if err != nil || !sc.Sampled || sc.Traceparent() != s {
+		t.Errorf("ParseTraceparent(%s) = %v %v", s, sc, err)
+	}
+
+	for _, bad := range []string{"", "00-xyz-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		_, err = ParseTraceparent(bad)
+		if err == nil {
+			t.Errorf("ParseTraceparent accepted: %s", bad)
+		}
+	}
+}
+
+func checkTrace(t *testing.T, exporter *InMemoryExporter) {
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	// the server span ends first
+	server, client := spans[0], spans[1]
+	if server.Kind != SpanKindServer || client.Kind != SpanKindClient {
+		t.Errorf("unexpected span kinds: %s %s", server.Kind, client.Kind)
+	}
+	if server.Parent != client.SpanContext || server.SpanContext.TraceId != client.SpanContext.TraceId {
+		t.Errorf("server span is not a child of client span")
+	}
+	if client.Parent.IsValid() {
+		t.Errorf("client span should be a root span")
+	}
+	if server.Attributes["rpc.service"] != "Counter" || server.Attributes["rpc.method"] != "total" {
+		t.Errorf("unexpected attributes: %v", server.Attributes)
+	}
+}
+
+func TestTracerHttp(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	svr := newCounterServer()
+	svr.AddFilter(tracer.Filter())
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	client := NewRemoteClient(&HttpTransport{Url: ts.URL}, true).(*RemoteClient)
+	client.AddInterceptor(tracer.Interceptor())
+
+	resultOk(client.Call("Counter.total", 4))
+	checkTrace(t, exporter)
+
+	// errors are recorded on both spans
+	exporter.Reset()
+	client.Call("Counter.count", -1)
+	for _, span := range exporter.Spans() {
+		if span.Err == nil || span.Attributes["rpc.jsonrpc.error_code"] != 1000 {
+			t.Errorf("error not recorded: %v", span.Attributes)
+		}
+	}
+}
+
+func TestTracerConn(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	svr := newCounterServer()
+	svr.AddFilter(tracer.Filter())
+	serverConn, clientConn := net.Pipe()
+	go svr.ServeConn(serverConn, newHeaders())
+
+	trans := NewConnTransport(clientConn)
+	defer trans.Close()
+	client := NewRemoteClient(trans, false).(*RemoteClient)
+	client.AddInterceptor(tracer.Interceptor())
+
+	_, err := CallContext(context.Background(), client, "Counter.total", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTrace(t, exporter)
+}
+
+// relayCounter forwards Total to another server with the call's context
+type relayCounter struct {
+	CounterImpl
+	headers Headers
+	client  Client
+}
+
+func (c relayCounter) CloneForReq(headers Headers) interface{} {
+	return relayCounter{headers: headers, client: c.client}
+}
+
+func (c relayCounter) Total(n int64) (int64, error) {
+	res, err := CallContext(c.headers.Context(), c.client, "Counter.total", n)
+	if err != nil {
+		return 0, err
+	}
+	return int64(res.(float64)), nil
+}
+
+func TestTracerHandlerContinuesTrace(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	svrB := newCounterServer()
+	svrB.AddFilter(tracer.Filter())
+	tsB := httptest.NewServer(&svrB)
+	defer tsB.Close()
+	clientB := NewRemoteClient(&HttpTransport{Url: tsB.URL}, true).(*RemoteClient)
+	clientB.AddInterceptor(tracer.Interceptor())
+
+	svrA := NewJSONServer(MustParseIdlJson([]byte(streamIdlJson)), true)
+	svrA.AddHandler("Counter", relayCounter{client: clientB})
+	svrA.AddFilter(tracer.Filter())
+
+	resultOk(svrA.Call(newHeaders(), "Counter.total", 4))
+
+	// server B, client of A's handler, server A
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	serverB, client, serverA := spans[0], spans[1], spans[2]
+	if serverA.Kind != SpanKindServer || client.Kind != SpanKindClient || serverB.Kind != SpanKindServer {
+		t.Errorf("unexpected span kinds: %s %s %s", serverA.Kind, client.Kind, serverB.Kind)
+	}
+	if serverB.SpanContext.TraceId != serverA.SpanContext.TraceId {
+		t.Errorf("server B started a new trace")
+	}
+	if client.Parent != serverA.SpanContext || serverB.Parent != client.SpanContext {
+		t.Errorf("spans are not nested: %v %v %v", serverA, client, serverB)
+	}
+}
+
+func TestTracerExportsRejectedCalls(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	svr := newCounterServer()
+	svr.AddFilter(NewTracer(exporter).Filter())
+	svr.AddFilter(ProxyFilter{
+		pre: func(r *RequestResponse) bool {
+			r.Err = &JsonRpcError{Code: -32001, Message: "rejected"}
+			return false
+		},
+		post: func(r *RequestResponse) bool { return true },
+	})
+
+	_, err := svr.Call(newHeaders(), "Counter.total", 2)
+	expectErrCode(t, err, -32001)
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].End.IsZero() || spans[0].Attributes["rpc.jsonrpc.error_code"] != -32001 {
+		t.Errorf("unexpected span: %v", spans[0])
+	}
+}
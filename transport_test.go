@@ -0,0 +1,376 @@
+package barrister
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRoundTripper counts the requests sent through it
+type countingRoundTripper struct {
+	n int32
+}
+
+func (c *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.n, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHttpTransportRoundTripper(t *testing.T) {
+	svr := newCounterServer()
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	rt := &countingRoundTripper{}
+	client := NewRemoteClient(&HttpTransport{Url: ts.URL, RoundTripper: rt}, true)
+
+	for i := 0; i < 3; i++ {
+		res, err := client.Call("Counter.total", 4)
+		if err != nil || res != 6.0 {
+			t.Errorf("Call returned %v %v", res, err)
+		}
+	}
+	if atomic.LoadInt32(&rt.n) != 3 {
+		t.Errorf("RoundTripper used %d times, expected 3", rt.n)
+	}
+}
+
+func TestHttpTransportTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	trans := &HttpTransport{Url: ts.URL, Timeout: 50 * time.Millisecond}
+	_, err := NewRemoteClient(trans, true).Call("Counter.total", 4)
+	e, ok := err.(*JsonRpcError)
+	if !ok || e.Code != -32603 {
+		t.Errorf("expected -32603 error after Timeout, got: %v", err)
+	}
+
+	// per-call deadlines apply when Timeout is disabled
+	trans.Timeout = -1
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = CallContext(ctx, NewRemoteClient(trans, true), "Counter.total", 4)
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("CallContext did not honor deadline: %v", err)
+	}
+}
+
+// flakyTransport fails the first n requests, then passes the rest to trans
+type flakyTransport struct {
+	n     int32
+	sent  int32
+	trans Transport
+}
+
+func (f *flakyTransport) Send(in []byte) ([]byte, error) {
+	if atomic.AddInt32(&f.sent, 1) <= f.n {
+		return nil, fmt.Errorf("connection refused")
+	}
+	return f.trans.Send(in)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	svr := newCounterServer()
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	idl := MustParseIdlJson([]byte(streamIdlJson))
+	retry := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Jitter: 0.5, Idempotent: idl.IsIdempotent}
+
+	trans := &flakyTransport{n: 2, trans: &HttpTransport{Url: ts.URL}}
+	client := &RemoteClient{Trans: trans, Ser: &JsonSerializer{}, Retry: retry}
+	res, err := client.Call("Counter.total", 4)
+	if err != nil || res != 6.0 || trans.sent != 3 {
+		t.Errorf("Call returned %v %v after %d attempts", res, err, trans.sent)
+	}
+
+	// gives up after MaxAttempts
+	trans = &flakyTransport{n: 5, trans: trans.trans}
+	client.Trans = trans
+	_, err = client.Call("Counter.total", 4)
+	if err == nil || trans.sent != 3 {
+		t.Errorf("Call returned %v after %d attempts", err, trans.sent)
+	}
+
+	// non-idempotent methods are not retried
+	trans = &flakyTransport{n: 1, trans: trans.trans}
+	client.Trans = trans
+	_, err = client.Call("Counter.count", 4)
+	if err == nil || trans.sent != 1 {
+		t.Errorf("Call returned %v after %d attempts", err, trans.sent)
+	}
+}
+
+// countingServer serves the Counter IDL, counts requests, and fails
+// with a 500 status while down is set
+type countingServer struct {
+	svr  Server
+	n    int32
+	down int32
+}
+
+func (c *countingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&c.down) == 1 {
+		http.Error(w, "down", 500)
+		return
+	}
+	atomic.AddInt32(&c.n, 1)
+	c.svr.ServeHTTP(w, r)
+}
+
+func newBalancedServers(n int) ([]*countingServer, []string, func()) {
+	servers := make([]*countingServer, n)
+	urls := make([]string, n)
+	closers := make([]func(), n)
+	for i := 0; i < n; i++ {
+		servers[i] = &countingServer{svr: newCounterServer()}
+		ts := httptest.NewServer(servers[i])
+		urls[i] = ts.URL
+		closers[i] = ts.Close
+	}
+	return servers, urls, func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+}
+
+func TestBalancingTransportRoundRobin(t *testing.T) {
+	servers, urls, closeAll := newBalancedServers(3)
+	defer closeAll()
+
+	trans, err := NewBalancingTransport(BalanceOptions{Endpoints: urls, MaxFailures: 2,
+		ProbeInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Close()
+	client := NewRemoteClient(trans, true)
+
+	for i := 0; i < 6; i++ {
+		client.Call("Counter.total", 4)
+	}
+	for i, s := range servers {
+		if atomic.LoadInt32(&s.n) != 2 {
+			t.Errorf("server %d received %d requests, expected 2", i, s.n)
+		}
+	}
+
+	// failing endpoint is ejected
+	atomic.StoreInt32(&servers[0].down, 1)
+	for i := 0; i < 9; i++ {
+		client.Call("Counter.total", 4)
+	}
+	if trans.Endpoints()[urls[0]] {
+		t.Errorf("failing endpoint was not ejected")
+	}
+
+	// and re-admitted once it answers probes
+	atomic.StoreInt32(&servers[0].down, 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for !trans.Endpoints()[urls[0]] && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !trans.Endpoints()[urls[0]] {
+		t.Errorf("recovered endpoint was not re-admitted")
+	}
+}
+
+func TestBalancingTransportConsistentHash(t *testing.T) {
+	servers, urls, closeAll := newBalancedServers(3)
+	defer closeAll()
+
+	trans, err := NewBalancingTransport(BalanceOptions{Endpoints: urls, Policy: ConsistentHash,
+		HashHeader: "X-Tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trans.Close()
+	client := NewRemoteClient(trans, true)
+
+	ctx := WithRequestHeader(context.Background(), "X-Tenant", "acme")
+	for i := 0; i < 5; i++ {
+		_, err = CallContext(ctx, client, "Counter.total", 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hit := 0
+	for _, s := range servers {
+		if atomic.LoadInt32(&s.n) == 5 {
+			hit++
+		}
+	}
+	if hit != 1 {
+		t.Errorf("requests with the same hash key were sent to more than one endpoint")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	svr := newCounterServer()
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	var changes []string
+	cb := NewCircuitBreaker(BreakerOptions{MinRequests: 2, OpenTimeout: 20 * time.Millisecond,
+		OnStateChange: func(name string, from, to CircuitState) {
+			changes = append(changes, fmt.Sprintf("%s:%s->%s", name, from, to))
+		}})
+
+	trans := &flakyTransport{n: 2, trans: &HttpTransport{Url: ts.URL}}
+	client := NewRemoteClient(cb.WrapTransport("ep1", trans), true)
+
+	client.Call("Counter.total", 4)
+	client.Call("Counter.total", 4)
+	if cb.State("ep1") != CircuitOpen {
+		t.Errorf("circuit did not open: %s", cb.State("ep1"))
+	}
+
+	_, err := client.Call("Counter.total", 4)
+	e, ok := err.(*JsonRpcError)
+	if !ok || e.Code != ErrCodeCircuitOpen || trans.sent != 2 {
+		t.Errorf("open circuit did not reject call: %v", err)
+	}
+
+	// trial call after OpenTimeout closes the circuit
+	time.Sleep(30 * time.Millisecond)
+	res, err := client.Call("Counter.total", 4)
+	if err != nil || res != 6.0 || cb.State("ep1") != CircuitClosed {
+		t.Errorf("trial call returned %v %v, state: %s", res, err, cb.State("ep1"))
+	}
+
+	expected := []string{"ep1:closed->open", "ep1:open->half-open", "ep1:half-open->closed"}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("%v != %v", changes, expected)
+	}
+
+	// handler errors do not open the per-method circuit
+	mc := cb.WrapClient(client)
+	for i := 0; i < 3; i++ {
+		mc.Call("Counter.count", -1)
+	}
+	if cb.State("Counter.count") != CircuitClosed {
+		t.Errorf("handler errors opened the circuit")
+	}
+}
+
+func TestCircuitBreakerIgnoresCanceledCalls(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	cb := NewCircuitBreaker(BreakerOptions{MinRequests: 1})
+	client := NewRemoteClient(cb.WrapTransport("ep1", &HttpTransport{Url: ts.URL}), true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := CallContext(ctx, client, "Counter.total", 4)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if cb.State("ep1") != CircuitClosed {
+		t.Errorf("canceled call opened the circuit")
+	}
+}
+
+// recordingInterceptor records the order of calls, and optionally
+// answers calls to one method without sending them
+type recordingInterceptor struct {
+	name  string
+	log   *[]string
+	local string
+}
+
+func (r recordingInterceptor) PreCall(c *ClientCall) bool {
+	*r.log = append(*r.log, r.name+".pre")
+	if c.Method == r.local {
+		c.Result = int64(42)
+		return false
+	}
+	c.Context = WithRequestHeader(c.Context, "X-Interceptor", r.name)
+	return true
+}
+
+func (r recordingInterceptor) PostCall(c *ClientCall) bool {
+	*r.log = append(*r.log, r.name+".post")
+	return true
+}
+
+func TestClientInterceptors(t *testing.T) {
+	var header []string
+	svr := newCounterServer()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header["X-Interceptor"]
+		svr.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	log := []string{}
+	client := NewRemoteClient(&HttpTransport{Url: ts.URL}, true).(*RemoteClient)
+	client.AddInterceptor(recordingInterceptor{name: "a", log: &log})
+	client.AddInterceptor(recordingInterceptor{name: "b", log: &log, local: "Counter.local"})
+
+	res, err := client.Call("Counter.total", 4)
+	if err != nil || res != 6.0 {
+		t.Errorf("Call returned %v %v", res, err)
+	}
+	expected := []string{"a.pre", "b.pre", "b.post", "a.post"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("%v != %v", log, expected)
+	}
+	if !reflect.DeepEqual(header, []string{"a", "b"}) {
+		t.Errorf("interceptor headers not sent: %v", header)
+	}
+
+	log = log[:0]
+	res, err = client.Call("Counter.local")
+	if err != nil || res != int64(42) || !reflect.DeepEqual(log, []string{"a.pre", "b.pre"}) {
+		t.Errorf("PreCall did not terminate chain: %v %v %v", res, err, log)
+	}
+}
+
+// versionFilter writes a response header and echoes a request header
+type versionFilter struct{}
+
+func (f versionFilter) PreInvoke(r *RequestResponse) bool {
+	r.Headers.Response["X-Version"] = []string{"1.2"}
+	r.Headers.Response["X-Echo"] = r.Headers.Request["X-Request-Id"]
+	return true
+}
+
+func (f versionFilter) PostInvoke(r *RequestResponse) bool {
+	return true
+}
+
+func TestCallWithMeta(t *testing.T) {
+	svr := newCounterServer()
+	svr.AddFilter(versionFilter{})
+	ts := httptest.NewServer(&svr)
+	defer ts.Close()
+
+	client := NewRemoteClient(&HttpTransport{Url: ts.URL}, true)
+	ctx := WithRequestHeader(context.Background(), "X-Request-Id", "abc")
+	res, meta, err := CallWithMeta(ctx, client, "Counter.total", 4)
+	if err != nil || res != 6.0 {
+		t.Fatalf("CallWithMeta returned %v %v", res, err)
+	}
+	if meta.StatusCode != 200 || meta.Header.Get("X-Version") != "1.2" ||
+		meta.Header.Get("X-Echo") != "abc" || meta.Duration <= 0 {
+		t.Errorf("unexpected meta: %+v", meta)
+	}
+}